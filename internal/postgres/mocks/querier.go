@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+)
+
+// Querier is a mock implementation of pglib.Querier.
+type Querier struct {
+	execCalls  atomic.Uint32
+	ExecFn     func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error)
+	QueryFn    func(ctx context.Context, query string, args ...any) (pglib.Rows, error)
+	QueryRowFn func(ctx context.Context, query string, args ...any) pglib.Row
+	CopyToFn   func(ctx context.Context, w io.Writer, query string) error
+	CopyFromFn func(ctx context.Context, r io.Reader, query string) (pglib.CommandTag, error)
+	CloseFn    func(ctx context.Context) error
+}
+
+func (m *Querier) Exec(ctx context.Context, query string, args ...any) (pglib.CommandTag, error) {
+	i := m.execCalls.Add(1)
+	return m.ExecFn(ctx, uint(i), query, args...)
+}
+
+func (m *Querier) Query(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+	return m.QueryFn(ctx, query, args...)
+}
+
+func (m *Querier) QueryRow(ctx context.Context, query string, args ...any) pglib.Row {
+	return m.QueryRowFn(ctx, query, args...)
+}
+
+func (m *Querier) CopyTo(ctx context.Context, w io.Writer, query string) error {
+	return m.CopyToFn(ctx, w, query)
+}
+
+func (m *Querier) CopyFrom(ctx context.Context, r io.Reader, query string) (pglib.CommandTag, error) {
+	return m.CopyFromFn(ctx, r, query)
+}
+
+func (m *Querier) Close(ctx context.Context) error {
+	if m.CloseFn == nil {
+		return nil
+	}
+	return m.CloseFn(ctx)
+}
@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+// Row is a mock implementation of pglib.Row.
+type Row struct {
+	ScanFn func(args ...any) error
+}
+
+func (m *Row) Scan(args ...any) error {
+	return m.ScanFn(args...)
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+import "sync/atomic"
+
+// Rows is a mock implementation of pglib.Rows.
+type Rows struct {
+	nextCalls atomic.Uint32
+	CloseFn   func()
+	NextFn    func(i uint) bool
+	ScanFn    func(dest ...any) error
+	ErrFn     func() error
+}
+
+func (m *Rows) Close() {
+	m.CloseFn()
+}
+
+func (m *Rows) Next() bool {
+	i := m.nextCalls.Add(1)
+	return m.NextFn(uint(i))
+}
+
+func (m *Rows) Scan(dest ...any) error {
+	return m.ScanFn(dest...)
+}
+
+func (m *Rows) Err() error {
+	return m.ErrFn()
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// CommandTag is the result of an Exec call, mirroring pgconn.CommandTag
+// without leaking the pgx dependency to callers.
+type CommandTag struct {
+	RowsAffectedCount int64
+}
+
+// Row represents a single row returned by QueryRow.
+type Row interface {
+	Scan(args ...any) error
+}
+
+// Rows represents a set of rows returned by Query.
+type Rows interface {
+	Close()
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// Querier is the minimal interface required to run queries against a
+// Postgres database, implemented by the pgx based connection and mocked
+// out in tests.
+type Querier interface {
+	Exec(ctx context.Context, query string, args ...any) (CommandTag, error)
+	Query(ctx context.Context, query string, args ...any) (Rows, error)
+	QueryRow(ctx context.Context, query string, args ...any) Row
+	// CopyTo streams the result of a `COPY ... TO STDOUT` query into w.
+	CopyTo(ctx context.Context, w io.Writer, query string) error
+	// CopyFrom feeds r into a `COPY ... FROM STDIN` query.
+	CopyFrom(ctx context.Context, r io.Reader, query string) (CommandTag, error)
+	Close(ctx context.Context) error
+}
+
+// QuerierBuilder builds a Querier for the given connection string.
+type QuerierBuilder func(ctx context.Context, url string) (Querier, error)
+
+// QuoteIdentifier quotes the given Postgres identifier so it can be safely
+// used in queries that don't support parameter binding (e.g pg_dump/pg_restore
+// CLI flags).
+func QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
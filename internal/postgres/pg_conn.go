@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"context"
+	"io"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgxQuerier adapts a pgxpool.Pool to the Querier interface. Because each
+// call acquires and releases its own connection from the pool, multi-call
+// session state (an open transaction, a SET TRANSACTION SNAPSHOT, deferred
+// constraints) isn't guaranteed to land on the same physical connection
+// across calls. Use NewConn instead when that's required.
+type pgxQuerier struct {
+	pool *pgxpool.Pool
+}
+
+// NewConnPool builds a Querier backed by a pgx connection pool for the
+// given connection string.
+func NewConnPool(ctx context.Context, url string) (Querier, error) {
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxQuerier{pool: pool}, nil
+}
+
+// pgxConn adapts a single, unpooled pgx.Conn to the Querier interface. Every
+// call runs against that one physical connection, so it's safe to pin
+// session state across calls (transactions, SET TRANSACTION SNAPSHOT,
+// SET CONSTRAINTS DEFERRED).
+type pgxConn struct {
+	conn *pgx.Conn
+}
+
+// NewConn opens a single, unpooled connection to the given URL. Use it
+// instead of NewConnPool when a sequence of calls must run on the same
+// physical connection, e.g. to hold an open transaction or exported
+// snapshot across them.
+func NewConn(ctx context.Context, url string) (Querier, error) {
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxConn{conn: conn}, nil
+}
+
+func (q *pgxQuerier) Exec(ctx context.Context, query string, args ...any) (CommandTag, error) {
+	tag, err := q.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return CommandTag{}, err
+	}
+	return CommandTag{RowsAffectedCount: tag.RowsAffected()}, nil
+}
+
+func (q *pgxQuerier) Query(ctx context.Context, query string, args ...any) (Rows, error) {
+	rows, err := q.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxRows{rows: rows}, nil
+}
+
+func (q *pgxQuerier) QueryRow(ctx context.Context, query string, args ...any) Row {
+	return q.pool.QueryRow(ctx, query, args...)
+}
+
+func (q *pgxQuerier) CopyTo(ctx context.Context, w io.Writer, query string) error {
+	conn, err := q.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Conn().PgConn().CopyTo(ctx, w, query)
+	return err
+}
+
+func (q *pgxQuerier) CopyFrom(ctx context.Context, r io.Reader, query string) (CommandTag, error) {
+	conn, err := q.pool.Acquire(ctx)
+	if err != nil {
+		return CommandTag{}, err
+	}
+	defer conn.Release()
+
+	tag, err := conn.Conn().PgConn().CopyFrom(ctx, r, query)
+	if err != nil {
+		return CommandTag{}, err
+	}
+	return CommandTag{RowsAffectedCount: tag.RowsAffected()}, nil
+}
+
+func (q *pgxQuerier) Close(ctx context.Context) error {
+	q.pool.Close()
+	return nil
+}
+
+func (q *pgxConn) Exec(ctx context.Context, query string, args ...any) (CommandTag, error) {
+	tag, err := q.conn.Exec(ctx, query, args...)
+	if err != nil {
+		return CommandTag{}, err
+	}
+	return CommandTag{RowsAffectedCount: tag.RowsAffected()}, nil
+}
+
+func (q *pgxConn) Query(ctx context.Context, query string, args ...any) (Rows, error) {
+	rows, err := q.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxRows{rows: rows}, nil
+}
+
+func (q *pgxConn) QueryRow(ctx context.Context, query string, args ...any) Row {
+	return q.conn.QueryRow(ctx, query, args...)
+}
+
+func (q *pgxConn) CopyTo(ctx context.Context, w io.Writer, query string) error {
+	_, err := q.conn.PgConn().CopyTo(ctx, w, query)
+	return err
+}
+
+func (q *pgxConn) CopyFrom(ctx context.Context, r io.Reader, query string) (CommandTag, error) {
+	tag, err := q.conn.PgConn().CopyFrom(ctx, r, query)
+	if err != nil {
+		return CommandTag{}, err
+	}
+	return CommandTag{RowsAffectedCount: tag.RowsAffected()}, nil
+}
+
+func (q *pgxConn) Close(ctx context.Context) error {
+	return q.conn.Close(ctx)
+}
+
+type pgxRows struct {
+	rows interface {
+		Close()
+		Next() bool
+		Scan(dest ...any) error
+		Err() error
+	}
+}
+
+func (r *pgxRows) Close()                 { r.rows.Close() }
+func (r *pgxRows) Next() bool             { return r.rows.Next() }
+func (r *pgxRows) Scan(dest ...any) error { return r.rows.Scan(dest...) }
+func (r *pgxRows) Err() error             { return r.rows.Err() }
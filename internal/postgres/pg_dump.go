@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// PGDump shells out to the pg_dump binary with the given options and
+// returns the resulting dump. Cancelling ctx interrupts the pg_dump
+// process.
+func PGDump(ctx context.Context, po PGDumpOptions) ([]byte, error) {
+	args := []string{"--dbname=" + po.ConnectionString, "--format=" + po.Format}
+	if po.Clean {
+		args = append(args, "--clean")
+	}
+	if po.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	if po.DataOnly {
+		args = append(args, "--data-only")
+	}
+	for _, s := range po.Schemas {
+		args = append(args, "--schema="+s)
+	}
+	for _, t := range po.ExcludeTables {
+		args = append(args, "--exclude-table="+t)
+	}
+	if po.SnapshotID != "" {
+		args = append(args, "--snapshot="+po.SnapshotID)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pg_dump", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pg_dump: %w: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// PGRestore shells out to the pg_restore binary, feeding it the given dump
+// on stdin, and returns its stderr output (where pg_restore reports
+// non-fatal issues even on success). Cancelling ctx interrupts the
+// pg_restore process.
+func PGRestore(ctx context.Context, po PGRestoreOptions, dump []byte) (string, error) {
+	args := []string{"--dbname=" + po.ConnectionString, "--format=" + po.Format}
+	if po.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+	if po.DataOnly {
+		args = append(args, "--data-only")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pg_restore", args...)
+	cmd.Stdin = bytes.NewReader(dump)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stderr.String(), err
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PGDumpOptions configures a pg_dump invocation.
+type PGDumpOptions struct {
+	ConnectionString string
+	Format           string
+	Clean            bool
+	SchemaOnly       bool
+	DataOnly         bool
+	Schemas          []string
+	ExcludeTables    []string
+	// SnapshotID, when set, tells pg_dump to run against a previously
+	// exported transaction snapshot (see pg_export_snapshot), so that
+	// multiple dump/copy operations can observe the exact same point in
+	// time.
+	SnapshotID string
+}
+
+// PGRestoreOptions configures a pg_restore invocation.
+type PGRestoreOptions struct {
+	ConnectionString string
+	SchemaOnly       bool
+	DataOnly         bool
+	Format           string
+}
+
+// ErrRelationAlreadyExists is returned by pg_restore when the relation being
+// restored already exists on the target. It's ignorable, since it means the
+// schema was already in place.
+type ErrRelationAlreadyExists struct {
+	Relation string
+}
+
+func (e *ErrRelationAlreadyExists) Error() string {
+	return fmt.Sprintf("relation %q already exists", e.Relation)
+}
+
+// PGRestoreErrors aggregates the individual errors parsed out of a
+// pg_restore run, since a single invocation can produce more than one.
+type PGRestoreErrors struct {
+	errs []error
+}
+
+// NewPGRestoreErrors builds a PGRestoreErrors wrapping the given errors.
+func NewPGRestoreErrors(errs ...error) *PGRestoreErrors {
+	return &PGRestoreErrors{errs: errs}
+}
+
+func (e *PGRestoreErrors) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *PGRestoreErrors) Unwrap() []error {
+	return e.errs
+}
+
+// Errs returns the individual errors aggregated by this PGRestoreErrors.
+func (e *PGRestoreErrors) Errs() []error {
+	return e.errs
+}
+
+// Ignorable returns true if every error aggregated in this PGRestoreErrors is
+// ignorable (e.g a relation already exists), meaning the snapshot can still
+// be considered successful.
+func (e *PGRestoreErrors) Ignorable() bool {
+	for _, err := range e.errs {
+		var alreadyExistsErr *ErrRelationAlreadyExists
+		if !isErrRelationAlreadyExists(err, &alreadyExistsErr) {
+			return false
+		}
+	}
+	return true
+}
+
+func isErrRelationAlreadyExists(err error, target **ErrRelationAlreadyExists) bool {
+	alreadyExistsErr, ok := err.(*ErrRelationAlreadyExists)
+	if ok {
+		*target = alreadyExistsErr
+	}
+	return ok
+}
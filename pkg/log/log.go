@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package log
+
+// Logger is the logging interface used throughout pgstream. It's kept
+// minimal so it can be backed by whatever structured logger the host
+// application already uses.
+type Logger interface {
+	Trace(msg string, fields ...Field)
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, err error, fields ...Field)
+	Error(msg string, err error, fields ...Field)
+	With(fields ...Field) Logger
+}
+
+// Field is a single structured logging key/value pair.
+type Field struct {
+	Key   string
+	Value any
+}
+
+type noopLogger struct{}
+
+// NewNoopLogger returns a Logger that discards everything it's given. Useful
+// as a safe default when no logger is configured.
+func NewNoopLogger() Logger {
+	return &noopLogger{}
+}
+
+func (n *noopLogger) Trace(msg string, fields ...Field)            {}
+func (n *noopLogger) Debug(msg string, fields ...Field)            {}
+func (n *noopLogger) Info(msg string, fields ...Field)             {}
+func (n *noopLogger) Warn(msg string, err error, fields ...Field)  {}
+func (n *noopLogger) Error(msg string, err error, fields ...Field) {}
+func (n *noopLogger) With(fields ...Field) Logger                  { return n }
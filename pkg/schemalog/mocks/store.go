@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+import (
+	"context"
+
+	"github.com/xataio/pgstream/pkg/schemalog"
+)
+
+// Store is a mock implementation of schemalog.Store.
+type Store struct {
+	InsertFn func(ctx context.Context, schemaName string) (*schemalog.LogEntry, error)
+	CloseFn  func() error
+}
+
+func (m *Store) Insert(ctx context.Context, schemaName string) (*schemalog.LogEntry, error) {
+	return m.InsertFn(ctx, schemaName)
+}
+
+func (m *Store) Close() error {
+	if m.CloseFn == nil {
+		return nil
+	}
+	return m.CloseFn()
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package schemalog
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// SchemaName is the Postgres schema the schema log table lives in.
+	SchemaName = "pgstream"
+	// TableName is the name of the schema log table.
+	TableName = "schema_log"
+)
+
+// LogEntry represents a single schema snapshot recorded in the schema log.
+type LogEntry struct {
+	ID         string
+	SchemaName string
+	CreatedAt  time.Time
+}
+
+// Store persists schema log entries, recording a new one every time a
+// schema snapshot is taken.
+type Store interface {
+	Insert(ctx context.Context, schemaName string) (*LogEntry, error)
+	Close() error
+}
@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package generator
+
+import (
+	"context"
+
+	"github.com/xataio/pgstream/pkg/snapshot"
+)
+
+// SnapshotGenerator is implemented by the different snapshotting backends
+// (postgres schema dump/restore, data snapshots...).
+type SnapshotGenerator interface {
+	CreateSnapshot(ctx context.Context, ss *snapshot.Snapshot) error
+	Close() error
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+)
+
+// DumpRestorer abstracts how a schema snapshot is taken from the source
+// database and applied to the target database, so SnapshotGenerator isn't
+// hard-wired to the pg_dump/pg_restore binaries.
+type DumpRestorer interface {
+	DumpSchema(ctx context.Context, opts pglib.PGDumpOptions) ([]byte, error)
+	DumpData(ctx context.Context, opts pglib.PGDumpOptions) ([]byte, error)
+	RestoreSchema(ctx context.Context, opts pglib.PGRestoreOptions, dump []byte) (string, error)
+	RestoreData(ctx context.Context, opts pglib.PGRestoreOptions, dump []byte) (string, error)
+}
+
+// shellDumpRestorer is the original DumpRestorer backend, shelling out to
+// the pg_dump/pg_restore binaries.
+type shellDumpRestorer struct {
+	pgDumpFn    pgdumpFn
+	pgRestoreFn pgrestoreFn
+}
+
+// newShellDumpRestorer returns the default DumpRestorer, backed by the
+// pg_dump/pg_restore binaries.
+func newShellDumpRestorer() *shellDumpRestorer {
+	return &shellDumpRestorer{
+		pgDumpFn:    pglib.PGDump,
+		pgRestoreFn: pglib.PGRestore,
+	}
+}
+
+func (b *shellDumpRestorer) DumpSchema(ctx context.Context, opts pglib.PGDumpOptions) ([]byte, error) {
+	opts.SchemaOnly = true
+	opts.DataOnly = false
+	return b.pgDumpFn(ctx, opts)
+}
+
+func (b *shellDumpRestorer) DumpData(ctx context.Context, opts pglib.PGDumpOptions) ([]byte, error) {
+	opts.SchemaOnly = false
+	opts.DataOnly = true
+	return b.pgDumpFn(ctx, opts)
+}
+
+func (b *shellDumpRestorer) RestoreSchema(ctx context.Context, opts pglib.PGRestoreOptions, dump []byte) (string, error) {
+	opts.SchemaOnly = true
+	opts.DataOnly = false
+	return b.pgRestoreFn(ctx, opts, dump)
+}
+
+func (b *shellDumpRestorer) RestoreData(ctx context.Context, opts pglib.PGRestoreOptions, dump []byte) (string, error) {
+	opts.SchemaOnly = false
+	opts.DataOnly = true
+	return b.pgRestoreFn(ctx, opts, dump)
+}
@@ -0,0 +1,310 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+	"github.com/xataio/pgstream/internal/postgres/mocks"
+)
+
+func TestCatalogDumpRestorer_DumpSchema(t *testing.T) {
+	t.Parallel()
+
+	testSchema := "test_schema"
+	errTest := errors.New("oh noes")
+
+	tests := []struct {
+		name string
+		conn pglib.Querier
+
+		wantDump string
+		wantErr  error
+	}{
+		{
+			name: "ok",
+			conn: &mocks.Querier{
+				QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+					switch {
+					case strings.Contains(query, "pg_sequences"):
+						return sequencesOf([]testSequence{{name: "users_id_seq", start: 1, increment: 1}}), nil
+					case strings.Contains(query, "pg_tables"):
+						return rowsOf([]string{"users"}), nil
+					case strings.Contains(query, "information_schema.columns"):
+						return columnsOf([]testColumn{
+							{name: "id", dataType: "integer", nullable: "NO"},
+							{name: "email", dataType: "text", nullable: "YES"},
+						}), nil
+					case strings.Contains(query, "contype = 'p'"):
+						return constraintsOf(nil), nil
+					case strings.Contains(query, "contype = 'u'"):
+						return constraintsOf(nil), nil
+					case strings.Contains(query, "contype = 'f'"):
+						return foreignKeysOf(nil), nil
+					case strings.Contains(query, "pg_indexes"):
+						return rowsOf(nil), nil
+					default:
+						return nil, fmt.Errorf("unexpected query: %s", query)
+					}
+				},
+				CloseFn: func(ctx context.Context) error { return nil },
+			},
+
+			wantDump: "CREATE SEQUENCE \"test_schema\".\"users_id_seq\" START 1 INCREMENT 1;\n" +
+				"CREATE TABLE \"test_schema\".\"users\" (\"id\" integer NOT NULL, \"email\" text);",
+			wantErr: nil,
+		},
+		{
+			name: "ok - primary key, unique, foreign key and index",
+			conn: &mocks.Querier{
+				QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+					switch {
+					case strings.Contains(query, "pg_sequences"):
+						return sequencesOf(nil), nil
+					case strings.Contains(query, "pg_tables"):
+						return rowsOf([]string{"orders"}), nil
+					case strings.Contains(query, "information_schema.columns"):
+						return columnsOf([]testColumn{{name: "id", dataType: "integer", nullable: "NO"}}), nil
+					case strings.Contains(query, "contype = 'p'"):
+						return constraintsOf([]testConstraint{{name: "orders_pkey", column: "id"}}), nil
+					case strings.Contains(query, "contype = 'u'"):
+						return constraintsOf([]testConstraint{{name: "orders_code_key", column: "code"}}), nil
+					case strings.Contains(query, "contype = 'f'"):
+						return foreignKeysOf([]testForeignKey{
+							{name: "orders_customer_id_fkey", column: "customer_id", parentSchema: "test_schema", parentTable: "customers", parentColumn: "id"},
+						}), nil
+					case strings.Contains(query, "pg_indexes"):
+						return rowsOf([]string{`CREATE INDEX orders_customer_id_idx ON test_schema.orders (customer_id)`}), nil
+					default:
+						return nil, fmt.Errorf("unexpected query: %s", query)
+					}
+				},
+				CloseFn: func(ctx context.Context) error { return nil },
+			},
+
+			wantDump: "CREATE TABLE \"test_schema\".\"orders\" (\"id\" integer NOT NULL);\n" +
+				"ALTER TABLE \"test_schema\".\"orders\" ADD CONSTRAINT \"orders_pkey\" PRIMARY KEY (\"id\");\n" +
+				"ALTER TABLE \"test_schema\".\"orders\" ADD CONSTRAINT \"orders_code_key\" UNIQUE (\"code\");\n" +
+				"ALTER TABLE \"test_schema\".\"orders\" ADD CONSTRAINT \"orders_customer_id_fkey\" FOREIGN KEY (\"customer_id\") REFERENCES \"test_schema\".\"customers\" (\"id\");\n" +
+				"CREATE INDEX orders_customer_id_idx ON test_schema.orders (customer_id);",
+			wantErr: nil,
+		},
+		{
+			name: "error - listing tables",
+			conn: &mocks.Querier{
+				QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+					switch {
+					case strings.Contains(query, "pg_sequences"):
+						return sequencesOf(nil), nil
+					case strings.Contains(query, "pg_tables"):
+						return nil, errTest
+					default:
+						return nil, fmt.Errorf("unexpected query: %s", query)
+					}
+				},
+				CloseFn: func(ctx context.Context) error { return nil },
+			},
+
+			wantDump: "",
+			wantErr:  errTest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			connBuilder := func(ctx context.Context, s string) (pglib.Querier, error) {
+				return tc.conn, nil
+			}
+			backend := newCatalogDumpRestorer(connBuilder, connBuilder)
+
+			dump, err := backend.DumpSchema(context.Background(), pglib.PGDumpOptions{
+				ConnectionString: "source-url",
+				Schemas:          []string{pglib.QuoteIdentifier(testSchema)},
+			})
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantDump, string(dump))
+		})
+	}
+}
+
+func TestCatalogDumpRestorer_DumpSchema_snapshotID(t *testing.T) {
+	t.Parallel()
+
+	var gotQueries []string
+	conn := &mocks.Querier{
+		ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+			gotQueries = append(gotQueries, query)
+			return pglib.CommandTag{}, nil
+		},
+		QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+			switch {
+			case strings.Contains(query, "pg_sequences"):
+				return sequencesOf(nil), nil
+			case strings.Contains(query, "pg_tables"):
+				return rowsOf(nil), nil
+			default:
+				return nil, fmt.Errorf("unexpected query: %s", query)
+			}
+		},
+		CloseFn: func(ctx context.Context) error { return nil },
+	}
+
+	var gotPooledConnCalls int
+	pooledConnBuilder := func(ctx context.Context, s string) (pglib.Querier, error) {
+		gotPooledConnCalls++
+		return nil, errors.New("pooled connBuilder: should not be used when a SnapshotID is set")
+	}
+	singleConnBuilder := func(ctx context.Context, s string) (pglib.Querier, error) {
+		return conn, nil
+	}
+	backend := newCatalogDumpRestorer(pooledConnBuilder, singleConnBuilder)
+
+	_, err := backend.DumpSchema(context.Background(), pglib.PGDumpOptions{
+		ConnectionString: "source-url",
+		Schemas:          []string{pglib.QuoteIdentifier("test_schema")},
+		SnapshotID:       "snapshot-id",
+	})
+	require.NoError(t, err)
+	require.Zero(t, gotPooledConnCalls)
+	require.Contains(t, gotQueries, "BEGIN ISOLATION LEVEL REPEATABLE READ")
+	require.Contains(t, gotQueries, "SET TRANSACTION SNAPSHOT 'snapshot-id'")
+}
+
+type testColumn struct {
+	name     string
+	dataType string
+	nullable string
+}
+
+func columnsOf(cols []testColumn) pglib.Rows {
+	i := -1
+	return &mocks.Rows{
+		CloseFn: func() {},
+		NextFn: func(uint) bool {
+			i++
+			return i < len(cols)
+		},
+		ScanFn: func(dest ...any) error {
+			*dest[0].(*string) = cols[i].name
+			*dest[1].(*string) = cols[i].dataType
+			*dest[2].(*string) = cols[i].nullable
+			*dest[3].(**string) = nil
+			return nil
+		},
+		ErrFn: func() error { return nil },
+	}
+}
+
+type testSequence struct {
+	name           string
+	start          int64
+	increment      int64
+	ownedTable     string
+	ownedColumn    string
+	hasOwnedColumn bool
+}
+
+func sequencesOf(seqs []testSequence) pglib.Rows {
+	i := -1
+	return &mocks.Rows{
+		CloseFn: func() {},
+		NextFn: func(uint) bool {
+			i++
+			return i < len(seqs)
+		},
+		ScanFn: func(dest ...any) error {
+			*dest[0].(*string) = seqs[i].name
+			*dest[1].(*int64) = seqs[i].start
+			*dest[2].(*int64) = seqs[i].increment
+			ownedTable := dest[3].(**string)
+			ownedColumn := dest[4].(**string)
+			if seqs[i].hasOwnedColumn {
+				*ownedTable = &seqs[i].ownedTable
+				*ownedColumn = &seqs[i].ownedColumn
+			} else {
+				*ownedTable = nil
+				*ownedColumn = nil
+			}
+			return nil
+		},
+		ErrFn: func() error { return nil },
+	}
+}
+
+type testConstraint struct {
+	name   string
+	column string
+}
+
+func constraintsOf(constraints []testConstraint) pglib.Rows {
+	i := -1
+	return &mocks.Rows{
+		CloseFn: func() {},
+		NextFn: func(uint) bool {
+			i++
+			return i < len(constraints)
+		},
+		ScanFn: func(dest ...any) error {
+			*dest[0].(*string) = constraints[i].name
+			*dest[1].(*string) = constraints[i].column
+			return nil
+		},
+		ErrFn: func() error { return nil },
+	}
+}
+
+type testForeignKey struct {
+	name         string
+	column       string
+	parentSchema string
+	parentTable  string
+	parentColumn string
+}
+
+func foreignKeysOf(fks []testForeignKey) pglib.Rows {
+	i := -1
+	return &mocks.Rows{
+		CloseFn: func() {},
+		NextFn: func(uint) bool {
+			i++
+			return i < len(fks)
+		},
+		ScanFn: func(dest ...any) error {
+			*dest[0].(*string) = fks[i].name
+			*dest[1].(*string) = fks[i].column
+			*dest[2].(*string) = fks[i].parentSchema
+			*dest[3].(*string) = fks[i].parentTable
+			*dest[4].(*string) = fks[i].parentColumn
+			return nil
+		},
+		ErrFn: func() error { return nil },
+	}
+}
+
+func rowsOf(values []string) pglib.Rows {
+	i := -1
+	return &mocks.Rows{
+		CloseFn: func() {},
+		NextFn: func(uint) bool {
+			i++
+			return i < len(values)
+		},
+		ScanFn: func(dest ...any) error {
+			*dest[0].(*string) = values[i]
+			return nil
+		},
+		ErrFn: func() error { return nil },
+	}
+}
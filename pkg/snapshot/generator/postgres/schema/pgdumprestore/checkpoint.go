@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"sync"
+)
+
+// CheckpointStatus tracks the progress of a single table through the
+// data-copy pipeline.
+type CheckpointStatus string
+
+const (
+	CheckpointStatusPending   CheckpointStatus = "pending"
+	CheckpointStatusDumping   CheckpointStatus = "dumping"
+	CheckpointStatusRestoring CheckpointStatus = "restoring"
+	CheckpointStatusDone      CheckpointStatus = "done"
+	CheckpointStatusFailed    CheckpointStatus = "failed"
+)
+
+// CheckpointKey uniquely identifies the table a checkpoint belongs to.
+type CheckpointKey struct {
+	SourceURL string
+	Schema    string
+	Table     string
+}
+
+// Checkpoint is the persisted state of a single table's data copy.
+type Checkpoint struct {
+	Status  CheckpointStatus
+	LastErr string
+	Attempt uint
+}
+
+// SnapshotCheckpointStore persists per-table data-copy progress so that a
+// failed or interrupted snapshot can be resumed without redoing tables
+// that already completed.
+type SnapshotCheckpointStore interface {
+	// Get returns the checkpoint for key, or nil if none has been recorded
+	// yet.
+	Get(ctx context.Context, key CheckpointKey) (*Checkpoint, error)
+	Set(ctx context.Context, key CheckpointKey, cp Checkpoint) error
+}
+
+// memCheckpointStore is an in-memory SnapshotCheckpointStore, useful for
+// tests and single-process snapshots that don't need to survive a restart.
+type memCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[CheckpointKey]Checkpoint
+}
+
+// NewMemCheckpointStore returns a SnapshotCheckpointStore backed by an
+// in-memory map.
+func NewMemCheckpointStore() SnapshotCheckpointStore {
+	return &memCheckpointStore{checkpoints: make(map[CheckpointKey]Checkpoint)}
+}
+
+func (s *memCheckpointStore) Get(ctx context.Context, key CheckpointKey) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.checkpoints[key]
+	if !ok {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func (s *memCheckpointStore) Set(ctx context.Context, key CheckpointKey, cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[key] = cp
+	return nil
+}
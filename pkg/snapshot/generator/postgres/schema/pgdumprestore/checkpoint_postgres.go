@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"fmt"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+)
+
+// checkpointSchema and checkpointTable hold the Postgres-backed
+// SnapshotCheckpointStore's state, created on demand in the same
+// pgstream schema used by the schemalog store.
+const (
+	checkpointSchema = "pgstream"
+	checkpointTable  = "snapshot_checkpoints"
+)
+
+// pgCheckpointStore is a SnapshotCheckpointStore backed by a table on a
+// Postgres database, so that checkpoints survive across process restarts
+// and can be shared by every snapshot attempt for the same source/target
+// pair. It holds a single connection pool for its lifetime instead of
+// dialing a new one on every Get/Set, since checkpointing happens once per
+// attempt per table and a snapshot can have thousands of tables.
+type pgCheckpointStore struct {
+	conn pglib.Querier
+}
+
+// NewPGCheckpointStore returns a SnapshotCheckpointStore that persists
+// checkpoints to a pgstream.snapshot_checkpoints table on url, creating it
+// on first use if it doesn't already exist.
+func NewPGCheckpointStore(ctx context.Context, url string) (SnapshotCheckpointStore, error) {
+	conn, err := pglib.NewConnPool(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &pgCheckpointStore{conn: conn}
+	if err := s.ensureTable(ctx); err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("ensuring snapshot checkpoints table exists: %w", err)
+	}
+	return s, nil
+}
+
+func (s *pgCheckpointStore) ensureTable(ctx context.Context) error {
+	if _, err := s.conn.Exec(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", checkpointSchema)); err != nil {
+		return err
+	}
+
+	_, err := s.conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		source_url text NOT NULL,
+		schema_name text NOT NULL,
+		table_name text NOT NULL,
+		status text NOT NULL,
+		last_err text NOT NULL DEFAULT '',
+		attempt integer NOT NULL DEFAULT 0,
+		PRIMARY KEY (source_url, schema_name, table_name)
+	)`, checkpointSchema, checkpointTable))
+	return err
+}
+
+func (s *pgCheckpointStore) Get(ctx context.Context, key CheckpointKey) (*Checkpoint, error) {
+	rows, err := s.conn.Query(ctx, fmt.Sprintf(`SELECT status, last_err, attempt FROM %s.%s
+		WHERE source_url = $1 AND schema_name = $2 AND table_name = $3`, checkpointSchema, checkpointTable),
+		key.SourceURL, key.Schema, key.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var status string
+	var cp Checkpoint
+	if err := rows.Scan(&status, &cp.LastErr, &cp.Attempt); err != nil {
+		return nil, err
+	}
+	cp.Status = CheckpointStatus(status)
+
+	return &cp, rows.Err()
+}
+
+func (s *pgCheckpointStore) Set(ctx context.Context, key CheckpointKey, cp Checkpoint) error {
+	_, err := s.conn.Exec(ctx, fmt.Sprintf(`INSERT INTO %s.%s (source_url, schema_name, table_name, status, last_err, attempt)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (source_url, schema_name, table_name)
+		DO UPDATE SET status = $4, last_err = $5, attempt = $6`, checkpointSchema, checkpointTable),
+		key.SourceURL, key.Schema, key.Table, string(cp.Status), cp.LastErr, cp.Attempt)
+	return err
+}
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+	"github.com/xataio/pgstream/internal/postgres/mocks"
+)
+
+func TestMemCheckpointStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemCheckpointStore()
+	key := CheckpointKey{SourceURL: "source-url", Schema: "test_schema", Table: "test_table"}
+
+	cp, err := store.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.Nil(t, cp)
+
+	require.NoError(t, store.Set(context.Background(), key, Checkpoint{Status: CheckpointStatusDone, Attempt: 1}))
+
+	cp, err = store.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.Equal(t, &Checkpoint{Status: CheckpointStatusDone, Attempt: 1}, cp)
+}
+
+func TestPGCheckpointStore(t *testing.T) {
+	t.Parallel()
+
+	key := CheckpointKey{SourceURL: "source-url", Schema: "test_schema", Table: "test_table"}
+
+	t.Run("Get - no checkpoint recorded", func(t *testing.T) {
+		t.Parallel()
+		store := &pgCheckpointStore{
+			conn: &mocks.Querier{
+				QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+					return &mocks.Rows{
+						CloseFn: func() {},
+						NextFn:  func(i uint) bool { return false },
+						ErrFn:   func() error { return nil },
+					}, nil
+				},
+			},
+		}
+
+		cp, err := store.Get(context.Background(), key)
+		require.NoError(t, err)
+		require.Nil(t, cp)
+	})
+
+	t.Run("Get - returns the recorded checkpoint", func(t *testing.T) {
+		t.Parallel()
+		i := -1
+		store := &pgCheckpointStore{
+			conn: &mocks.Querier{
+				QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+					return &mocks.Rows{
+						CloseFn: func() {},
+						NextFn: func(uint) bool {
+							i++
+							return i == 0
+						},
+						ScanFn: func(dest ...any) error {
+							*dest[0].(*string) = string(CheckpointStatusDone)
+							*dest[1].(*string) = "oh noes"
+							*dest[2].(*uint) = 2
+							return nil
+						},
+						ErrFn: func() error { return nil },
+					}, nil
+				},
+			},
+		}
+
+		cp, err := store.Get(context.Background(), key)
+		require.NoError(t, err)
+		require.Equal(t, &Checkpoint{Status: CheckpointStatusDone, LastErr: "oh noes", Attempt: 2}, cp)
+	})
+
+	t.Run("Set - persists the checkpoint via an upsert", func(t *testing.T) {
+		t.Parallel()
+		var gotQuery string
+		var gotArgs []any
+		store := &pgCheckpointStore{
+			conn: &mocks.Querier{
+				ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+					gotQuery = query
+					gotArgs = args
+					return pglib.CommandTag{}, nil
+				},
+			},
+		}
+
+		err := store.Set(context.Background(), key, Checkpoint{Status: CheckpointStatusDone, Attempt: 1})
+		require.NoError(t, err)
+		require.Contains(t, gotQuery, "ON CONFLICT")
+		require.Equal(t, []any{key.SourceURL, key.Schema, key.Table, string(CheckpointStatusDone), "", uint(1)}, gotArgs)
+	})
+}
+
+func TestSnapshotGenerator_copyTableWithCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("oh noes")
+	testTable := tableToCopy{Schema: "test_schema", Table: "test_table"}
+
+	tests := []struct {
+		name            string
+		checkpointStore SnapshotCheckpointStore
+		maxAttempts     uint
+		dataCopyFn      dataCopyFn
+
+		wantErr      error
+		wantCalls    int
+		wantStatus   CheckpointStatus
+		wantNoLookup bool
+	}{
+		{
+			name:            "no checkpoint store - calls dataCopyFn directly",
+			checkpointStore: nil,
+			dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+				return nil
+			},
+
+			wantErr:      nil,
+			wantCalls:    1,
+			wantNoLookup: true,
+		},
+		{
+			name:            "skips table already marked done",
+			checkpointStore: checkpointStoreWith(t, testTable, Checkpoint{Status: CheckpointStatusDone, Attempt: 1}),
+			maxAttempts:     3,
+			dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+				return errors.New("dataCopyFn: should not be called")
+			},
+
+			wantErr:    nil,
+			wantCalls:  0,
+			wantStatus: CheckpointStatusDone,
+		},
+		{
+			name:            "retries a failing table and succeeds within maxAttempts",
+			checkpointStore: NewMemCheckpointStore(),
+			maxAttempts:     3,
+			dataCopyFn: failNTimes(2, func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+				return nil
+			}),
+
+			wantErr:    nil,
+			wantCalls:  3,
+			wantStatus: CheckpointStatusDone,
+		},
+		{
+			name:            "gives up once maxAttempts is exhausted",
+			checkpointStore: NewMemCheckpointStore(),
+			maxAttempts:     2,
+			dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+				return errTest
+			},
+
+			wantErr:    errTest,
+			wantCalls:  2,
+			wantStatus: CheckpointStatusFailed,
+		},
+		{
+			name:            "ignorable error is treated as success",
+			checkpointStore: NewMemCheckpointStore(),
+			maxAttempts:     3,
+			dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+				return pglib.NewPGRestoreErrors(&pglib.ErrRelationAlreadyExists{Relation: "test_table"})
+			},
+
+			wantErr:    nil,
+			wantCalls:  1,
+			wantStatus: CheckpointStatusDone,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			dataCopyFn := tc.dataCopyFn
+			sg := &SnapshotGenerator{
+				sourceURL:       "source-url",
+				checkpointStore: tc.checkpointStore,
+				maxAttempts:     tc.maxAttempts,
+				dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+					calls++
+					return dataCopyFn(ctx, table, targetConn)
+				},
+			}
+
+			err := sg.copyTableWithCheckpoint(context.Background(), testTable, nil)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.wantCalls, calls)
+
+			if tc.wantNoLookup {
+				return
+			}
+			cp, err := tc.checkpointStore.Get(context.Background(), CheckpointKey{SourceURL: "source-url", Schema: testTable.Schema, Table: testTable.Table})
+			require.NoError(t, err)
+			require.NotNil(t, cp)
+			require.Equal(t, tc.wantStatus, cp.Status)
+		})
+	}
+}
+
+// failNTimes returns a dataCopyFn that fails its first n calls with a test
+// error and delegates to fn afterwards.
+func failNTimes(n int, fn dataCopyFn) dataCopyFn {
+	calls := 0
+	return func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+		calls++
+		if calls <= n {
+			return errors.New("transient failure")
+		}
+		return fn(ctx, table, targetConn)
+	}
+}
+
+// checkpointStoreWith returns a memCheckpointStore pre-seeded with cp for
+// table.
+func checkpointStoreWith(t *testing.T, table tableToCopy, cp Checkpoint) SnapshotCheckpointStore {
+	t.Helper()
+	store := NewMemCheckpointStore()
+	require.NoError(t, store.Set(context.Background(), CheckpointKey{SourceURL: "source-url", Schema: table.Schema, Table: table.Table}, cp))
+	return store
+}
@@ -0,0 +1,363 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pgdumprestore implements a snapshot generator that takes a
+// schema snapshot of a source Postgres database and applies it to a
+// target Postgres database, via a pluggable DumpRestorer backend (the
+// pg_dump/pg_restore binaries by default).
+package pgdumprestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+	loglib "github.com/xataio/pgstream/pkg/log"
+	"github.com/xataio/pgstream/pkg/schemalog"
+	"github.com/xataio/pgstream/pkg/snapshot"
+)
+
+// publicSchema is the default Postgres schema.
+const publicSchema = "public"
+
+type pgdumpFn func(ctx context.Context, opts pglib.PGDumpOptions) ([]byte, error)
+type pgrestoreFn func(ctx context.Context, opts pglib.PGRestoreOptions, dump []byte) (string, error)
+
+// SnapshotGenerator generates a schema snapshot of a source Postgres
+// database and applies it to a target Postgres database via a
+// DumpRestorer backend.
+type SnapshotGenerator struct {
+	sourceURL           string
+	targetURL           string
+	connBuilder         pglib.QuerierBuilder
+	singleConnBuilder   pglib.QuerierBuilder
+	backend             DumpRestorer
+	schemalogStore      schemalog.Store
+	logger              loglib.Logger
+	dataCopyConcurrency uint
+	dataCopyFn          dataCopyFn
+	fkDependenciesFn    fkDependenciesFn
+	consistentSnapshot  bool
+	exportSnapshotFn    exportSnapshotFn
+	checkpointStore     SnapshotCheckpointStore
+	maxAttempts         uint
+	retryBackoff        time.Duration
+	subsetPolicy        *SubsetPolicy
+	fkGraphFn           fkGraphFn
+	primaryKeyColumnFn  primaryKeyColumnFn
+}
+
+// Option configures a SnapshotGenerator created via NewSnapshotGenerator.
+type Option func(*SnapshotGenerator)
+
+// WithSchemaLogStore configures the generator to record a schema log entry
+// after every successful schema snapshot.
+func WithSchemaLogStore(store schemalog.Store) Option {
+	return func(sg *SnapshotGenerator) {
+		sg.schemalogStore = store
+	}
+}
+
+// WithLogger sets the logger used by the generator.
+func WithLogger(logger loglib.Logger) Option {
+	return func(sg *SnapshotGenerator) {
+		sg.logger = logger
+	}
+}
+
+// WithDataCopyConcurrency enables a data-copy phase after the schema is
+// restored, streaming rows for up to concurrency tables at a time. When
+// unset (the default), the generator only snapshots the schema, matching
+// its original pg_dump/pg_restore-only behaviour.
+func WithDataCopyConcurrency(concurrency uint) Option {
+	return func(sg *SnapshotGenerator) {
+		sg.dataCopyConcurrency = concurrency
+	}
+}
+
+// WithConsistentSnapshot makes the generator export a single Postgres
+// transaction snapshot on the source before dumping the schema, and reuses
+// it for the schema dump and every per-table data-copy worker, so the
+// overall snapshot reflects one consistent point in time even when
+// DataCopyConcurrency is greater than one.
+func WithConsistentSnapshot() Option {
+	return func(sg *SnapshotGenerator) {
+		sg.consistentSnapshot = true
+	}
+}
+
+// WithDumpRestorer overrides the DumpRestorer backend used to take and
+// apply the schema snapshot. Defaults to the pg_dump/pg_restore binary
+// backend; use WithCatalogBackend to switch to the native pgx one.
+func WithDumpRestorer(backend DumpRestorer) Option {
+	return func(sg *SnapshotGenerator) {
+		sg.backend = backend
+	}
+}
+
+// WithCatalogBackend switches the generator to the pure Go, pgx based
+// DumpRestorer that reconstructs DDL from pg_catalog instead of shelling
+// out to pg_dump/pg_restore. Useful when the source database doesn't allow
+// running the pg_dump binary against it (e.g. some managed read replicas).
+func WithCatalogBackend() Option {
+	return func(sg *SnapshotGenerator) {
+		sg.backend = newCatalogDumpRestorer(sg.connBuilder, sg.singleConnBuilder)
+	}
+}
+
+// WithCheckpointStore makes the generator persist per-table data-copy
+// progress to store, so that a snapshot interrupted or partially failed
+// part way through the data-copy phase can be resumed without recopying
+// tables that already completed. Tables left in a failed state are retried
+// up to MaxAttempts (see WithMaxAttempts).
+func WithCheckpointStore(store SnapshotCheckpointStore) Option {
+	return func(sg *SnapshotGenerator) {
+		sg.checkpointStore = store
+	}
+}
+
+// WithMaxAttempts caps the number of times a table's data copy is retried,
+// with exponential backoff between attempts, before CreateSnapshot gives up
+// on it. Only takes effect when a CheckpointStore is configured. Defaults
+// to 1 (no retry).
+func WithMaxAttempts(maxAttempts uint) Option {
+	return func(sg *SnapshotGenerator) {
+		sg.maxAttempts = maxAttempts
+	}
+}
+
+// WithSubsetPolicy makes the generator copy a referentially consistent
+// subset of the source data, selected by policy, instead of every row. It
+// takes precedence over WithDataCopyConcurrency.
+func WithSubsetPolicy(policy SubsetPolicy) Option {
+	return func(sg *SnapshotGenerator) {
+		sg.subsetPolicy = &policy
+	}
+}
+
+// NewSnapshotGenerator returns a SnapshotGenerator that dumps the schema
+// from sourceURL and restores it into targetURL.
+func NewSnapshotGenerator(ctx context.Context, sourceURL, targetURL string, opts ...Option) (*SnapshotGenerator, error) {
+	sg := &SnapshotGenerator{
+		sourceURL:          sourceURL,
+		targetURL:          targetURL,
+		connBuilder:        pglib.NewConnPool,
+		singleConnBuilder:  pglib.NewConn,
+		backend:            newShellDumpRestorer(),
+		logger:             loglib.NewNoopLogger(),
+		fkDependenciesFn:   findFKDependencies,
+		exportSnapshotFn:   exportSnapshot,
+		maxAttempts:        1,
+		retryBackoff:       defaultRetryBackoff,
+		fkGraphFn:          findFKGraph,
+		primaryKeyColumnFn: findPrimaryKeyColumn,
+	}
+	sg.dataCopyFn = sg.copyTableData
+
+	for _, opt := range opts {
+		opt(sg)
+	}
+
+	return sg, nil
+}
+
+// CreateSnapshot dumps the schema for ss.SchemaName from the source
+// database, excluding any tables not listed in ss.TableNames (unless
+// ss.TableNames is the wildcard "*"), and restores it into the target
+// database.
+func (sg *SnapshotGenerator) CreateSnapshot(ctx context.Context, ss *snapshot.Snapshot) error {
+	if len(ss.TableNames) == 0 {
+		return nil
+	}
+
+	var snapshotID string
+	if sg.consistentSnapshot {
+		snapshotConn, id, err := sg.exportSnapshotFn(ctx, sg.singleConnBuilder, sg.sourceURL)
+		if err != nil {
+			return fmt.Errorf("exporting consistent snapshot: %w", err)
+		}
+		defer func() {
+			_, _ = snapshotConn.Exec(ctx, "COMMIT")
+			snapshotConn.Close(ctx)
+		}()
+		snapshotID = id
+	}
+
+	dumpOpts := pglib.PGDumpOptions{
+		ConnectionString: sg.sourceURL,
+		Format:           "p",
+		SchemaOnly:       true,
+		Schemas:          []string{pglib.QuoteIdentifier(ss.SchemaName)},
+		SnapshotID:       snapshotID,
+	}
+
+	if !isWildcard(ss.TableNames) {
+		excludedTables, err := sg.findExcludedTables(ctx, ss.SchemaName, ss.TableNames)
+		if err != nil {
+			return err
+		}
+		dumpOpts.ExcludeTables = quoteIdentifiers(excludedTables)
+	}
+
+	dump, err := sg.backend.DumpSchema(ctx, dumpOpts)
+	if err != nil {
+		return err
+	}
+
+	targetConn, err := sg.connBuilder(ctx, sg.targetURL)
+	if err != nil {
+		return err
+	}
+	defer targetConn.Close(ctx)
+
+	if _, err := targetConn.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+ss.SchemaName); err != nil {
+		return err
+	}
+
+	if _, err := sg.backend.RestoreSchema(ctx, pglib.PGRestoreOptions{
+		ConnectionString: sg.targetURL,
+		SchemaOnly:       true,
+		Format:           "p",
+	}, dump); err != nil {
+		var restoreErrs *pglib.PGRestoreErrors
+		if !errors.As(err, &restoreErrs) || !restoreErrs.Ignorable() {
+			return err
+		}
+		sg.logger.Warn("ignoring non-fatal pg_restore errors", err)
+	}
+
+	switch {
+	case sg.subsetPolicy != nil:
+		if err := sg.copySubset(ctx, ss.SchemaName, *sg.subsetPolicy, snapshotID); err != nil {
+			return err
+		}
+	case sg.dataCopyConcurrency > 0:
+		tableNames := ss.TableNames
+		if isWildcard(tableNames) {
+			var err error
+			tableNames, err = sg.findAllTables(ctx, ss.SchemaName)
+			if err != nil {
+				return fmt.Errorf("listing tables for data copy: %w", err)
+			}
+		}
+		if err := sg.copyData(ctx, ss.SchemaName, tableNames, snapshotID); err != nil {
+			return err
+		}
+	}
+
+	if sg.schemalogStore != nil {
+		if _, err := sg.schemalogStore.Insert(ctx, ss.SchemaName); err != nil {
+			return fmt.Errorf("inserting schemalog entry after schema snapshot: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close releases any resources held by the generator.
+func (sg *SnapshotGenerator) Close() error {
+	return nil
+}
+
+// findExcludedTables returns the tables in schemaName that are not part of
+// tableNames, so they can be excluded from the pg_dump snapshot.
+func (sg *SnapshotGenerator) findExcludedTables(ctx context.Context, schemaName string, tableNames []string) ([]string, error) {
+	conn, err := sg.connBuilder(ctx, sg.sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(ctx)
+
+	placeholders := make([]string, 0, len(tableNames))
+	args := make([]any, 0, len(tableNames)+1)
+	args = append(args, schemaName)
+	for i, tableName := range tableNames {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+2))
+		args = append(args, tableName)
+	}
+
+	query := fmt.Sprintf("SELECT tablename FROM pg_tables WHERE schemaname = $1 AND tablename NOT IN (%s)", strings.Join(placeholders, ","))
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var excludedTables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, err
+		}
+		excludedTables = append(excludedTables, tableName)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return excludedTables, nil
+}
+
+// findAllTables returns every table in schemaName on the source database.
+func (sg *SnapshotGenerator) findAllTables(ctx context.Context, schemaName string) ([]string, error) {
+	conn, err := sg.connBuilder(ctx, sg.sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, "SELECT tablename FROM pg_tables WHERE schemaname = $1", schemaName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tableNames []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, err
+		}
+		tableNames = append(tableNames, tableName)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tableNames, nil
+}
+
+// schemalogExists returns whether the schemalog table already exists on the
+// source database.
+func (sg *SnapshotGenerator) schemalogExists(ctx context.Context) (bool, error) {
+	conn, err := sg.connBuilder(ctx, sg.sourceURL)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(ctx)
+
+	var exists bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2)", schemalog.SchemaName, schemalog.TableName).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func isWildcard(tableNames []string) bool {
+	return len(tableNames) == 1 && tableNames[0] == "*"
+}
+
+func quoteIdentifiers(identifiers []string) []string {
+	if len(identifiers) == 0 {
+		return nil
+	}
+	quoted := make([]string, 0, len(identifiers))
+	for _, i := range identifiers {
+		quoted = append(quoted, pglib.QuoteIdentifier(i))
+	}
+	return quoted
+}
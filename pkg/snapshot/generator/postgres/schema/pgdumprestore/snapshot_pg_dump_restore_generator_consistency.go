@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"fmt"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+)
+
+// exportSnapshotFn opens a new connection on sourceURL, starts a
+// REPEATABLE READ transaction and exports its snapshot, returning the
+// connection (with the transaction left open) and the exported snapshot
+// ID. The caller is responsible for committing and closing the connection
+// once every consumer of the snapshot ID is done with it. connBuilder must
+// return a Querier pinned to a single physical connection (e.g.
+// pglib.NewConn) so the open transaction survives across calls; a pooled
+// one (pglib.NewConnPool) may hand the BEGIN and the SELECT
+// pg_export_snapshot() to different connections.
+type exportSnapshotFn func(ctx context.Context, connBuilder pglib.QuerierBuilder, sourceURL string) (pglib.Querier, string, error)
+
+// exportSnapshot is the default exportSnapshotFn, implemented via
+// `SELECT pg_export_snapshot()`.
+func exportSnapshot(ctx context.Context, connBuilder pglib.QuerierBuilder, sourceURL string) (pglib.Querier, string, error) {
+	conn, err := connBuilder(ctx, sourceURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := conn.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ"); err != nil {
+		conn.Close(ctx)
+		return nil, "", err
+	}
+
+	var snapshotID string
+	if err := conn.QueryRow(ctx, "SELECT pg_export_snapshot()").Scan(&snapshotID); err != nil {
+		_, _ = conn.Exec(ctx, "ROLLBACK")
+		conn.Close(ctx)
+		return nil, "", fmt.Errorf("exporting snapshot: %w", err)
+	}
+
+	return conn, snapshotID, nil
+}
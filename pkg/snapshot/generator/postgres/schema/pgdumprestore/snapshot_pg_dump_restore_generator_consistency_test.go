@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+	"github.com/xataio/pgstream/internal/postgres/mocks"
+	"github.com/xataio/pgstream/pkg/log"
+	"github.com/xataio/pgstream/pkg/snapshot"
+)
+
+func TestExportSnapshot(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("oh noes")
+	testSnapshotID := "00000003-0000001A-1"
+
+	tests := []struct {
+		name        string
+		connBuilder pglib.QuerierBuilder
+
+		wantSnapshotID string
+		wantErr        error
+	}{
+		{
+			name: "ok",
+			connBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+				return &mocks.Querier{
+					ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+						require.Equal(t, "BEGIN ISOLATION LEVEL REPEATABLE READ", query)
+						return pglib.CommandTag{}, nil
+					},
+					QueryRowFn: func(ctx context.Context, query string, args ...any) pglib.Row {
+						require.Equal(t, "SELECT pg_export_snapshot()", query)
+						return &mocks.Row{
+							ScanFn: func(args ...any) error {
+								id, ok := args[0].(*string)
+								require.True(t, ok)
+								*id = testSnapshotID
+								return nil
+							},
+						}
+					},
+				}, nil
+			},
+
+			wantSnapshotID: testSnapshotID,
+			wantErr:        nil,
+		},
+		{
+			name: "error - connecting to source",
+			connBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+				return nil, errTest
+			},
+
+			wantSnapshotID: "",
+			wantErr:        errTest,
+		},
+		{
+			name: "error - starting transaction",
+			connBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+				return &mocks.Querier{
+					ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+						return pglib.CommandTag{}, errTest
+					},
+					CloseFn: func(ctx context.Context) error { return nil },
+				}, nil
+			},
+
+			wantSnapshotID: "",
+			wantErr:        errTest,
+		},
+		{
+			name: "error - exporting snapshot",
+			connBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+				return &mocks.Querier{
+					ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+						return pglib.CommandTag{}, nil
+					},
+					QueryRowFn: func(ctx context.Context, query string, args ...any) pglib.Row {
+						return &mocks.Row{
+							ScanFn: func(args ...any) error { return errTest },
+						}
+					},
+					CloseFn: func(ctx context.Context) error { return nil },
+				}, nil
+			},
+
+			wantSnapshotID: "",
+			wantErr:        errTest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, snapshotID, err := exportSnapshot(context.Background(), tc.connBuilder, "source-url")
+			require.ErrorIs(t, err, tc.wantErr)
+			require.Equal(t, tc.wantSnapshotID, snapshotID)
+		})
+	}
+}
+
+func TestSnapshotGenerator_CreateSnapshot_consistentSnapshot(t *testing.T) {
+	t.Parallel()
+
+	testSchema := "test_schema"
+	testTable := "test_table"
+	testSnapshotID := "00000003-0000001A-1"
+	testDump := []byte("test dump")
+
+	var gotSnapshotIDInDump string
+	var gotSnapshotIDInCopy string
+
+	sourceConn := func(ctx context.Context, s string) (pglib.Querier, error) {
+		return &mocks.Querier{
+			ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+				return pglib.CommandTag{}, nil
+			},
+			QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+				return &mocks.Rows{
+					CloseFn: func() {},
+					NextFn:  func(i uint) bool { return false },
+					ErrFn:   func() error { return nil },
+				}, nil
+			},
+			QueryRowFn: func(ctx context.Context, query string, args ...any) pglib.Row {
+				return &mocks.Row{
+					ScanFn: func(args ...any) error {
+						id, ok := args[0].(*string)
+						require.True(t, ok)
+						*id = testSnapshotID
+						return nil
+					},
+				}
+			},
+			CloseFn: func(ctx context.Context) error { return nil },
+		}, nil
+	}
+
+	sg := &SnapshotGenerator{
+		sourceURL:         "source-url",
+		targetURL:         "target-url",
+		connBuilder:       sourceConn,
+		singleConnBuilder: sourceConn,
+		backend: &shellDumpRestorer{
+			pgDumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
+				gotSnapshotIDInDump = po.SnapshotID
+				return testDump, nil
+			},
+			pgRestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
+				return "", nil
+			},
+		},
+		fkDependenciesFn: func(ctx context.Context, conn pglib.Querier, schemaName string, tableNames []string) (map[string][]string, error) {
+			return map[string][]string{testTable: nil}, nil
+		},
+		dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+			gotSnapshotIDInCopy = table.SnapshotID
+			return nil
+		},
+		dataCopyConcurrency: 1,
+		consistentSnapshot:  true,
+		exportSnapshotFn:    exportSnapshot,
+		logger:              log.NewNoopLogger(),
+	}
+
+	err := sg.CreateSnapshot(context.Background(), &snapshot.Snapshot{
+		SchemaName: testSchema,
+		TableNames: []string{testTable},
+	})
+	require.NoError(t, err)
+	require.Equal(t, testSnapshotID, gotSnapshotIDInDump)
+	require.Equal(t, testSnapshotID, gotSnapshotIDInCopy)
+}
@@ -0,0 +1,385 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+)
+
+// defaultRetryBackoff is the base delay between retry attempts for a
+// table's data copy, doubled for every attempt beyond the first.
+const defaultRetryBackoff = time.Second
+
+// tableToCopy identifies a single table to stream data for.
+type tableToCopy struct {
+	Schema string
+	Table  string
+	// SnapshotID, when set, is the exported transaction snapshot the copy
+	// must read from, so it observes the same point in time as every other
+	// table being copied.
+	SnapshotID string
+}
+
+// dataCopyFn copies the rows of a single table from the source database to
+// the target database. When targetConn is non-nil, it's used instead of
+// dialing a new target connection, so that callers needing every table copy
+// to run on the same physical connection/transaction (e.g. to defer FK
+// constraints across an FK cycle) can pin one.
+type dataCopyFn func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error
+
+// fkDependenciesFn returns, for every table in tableNames, the subset of
+// tableNames it has a foreign key pointing to (its parents).
+type fkDependenciesFn func(ctx context.Context, conn pglib.Querier, schemaName string, tableNames []string) (map[string][]string, error)
+
+// copyData streams the data for every table in tableNames from the source
+// database into the target database, honouring sg.dataCopyConcurrency.
+// Tables are copied in FK dependency order so parents load before their
+// children; if the FK graph has a cycle, constraints are deferred and the
+// tables are copied sequentially within a single target transaction
+// instead.
+func (sg *SnapshotGenerator) copyData(ctx context.Context, schemaName string, tableNames []string, snapshotID string) error {
+	if len(tableNames) == 0 {
+		return nil
+	}
+
+	conn, err := sg.connBuilder(ctx, sg.sourceURL)
+	if err != nil {
+		return err
+	}
+	deps, err := sg.fkDependenciesFn(ctx, conn, schemaName, tableNames)
+	conn.Close(ctx)
+	if err != nil {
+		return err
+	}
+
+	ordered, cyclic := sortByFKDependency(tableNames, deps)
+	if cyclic {
+		sg.logger.Warn("FK dependency cycle detected, deferring constraints and copying sequentially", nil)
+		return sg.copyTablesWithDeferredConstraints(ctx, schemaName, ordered, snapshotID)
+	}
+
+	return sg.copyTablesConcurrently(ctx, schemaName, ordered, deps, snapshotID)
+}
+
+// copyTablesConcurrently copies every table in ordered, running up to
+// sg.dataCopyConcurrency workers at a time, without starting a table before
+// every table it depends on has completed.
+func (sg *SnapshotGenerator) copyTablesConcurrently(ctx context.Context, schemaName string, ordered []string, deps map[string][]string, snapshotID string) error {
+	done := make(map[string]chan struct{}, len(ordered))
+	for _, table := range ordered {
+		done[table] = make(chan struct{})
+	}
+
+	sem := make(chan struct{}, sg.dataCopyConcurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	for _, table := range ordered {
+		wg.Add(1)
+		go func(table string) {
+			defer wg.Done()
+			defer close(done[table])
+
+			for _, parent := range deps[table] {
+				<-done[parent]
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := sg.copyTableWithCheckpoint(ctx, tableToCopy{Schema: schemaName, Table: table, SnapshotID: snapshotID}, nil); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("copying table %s.%s: %w", schemaName, table, err))
+				mu.Unlock()
+			}
+		}(table)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return pglib.NewPGRestoreErrors(errs...)
+	}
+	return nil
+}
+
+// copyTablesWithDeferredConstraints copies tables one at a time inside a
+// single target transaction with constraints deferred, used as a fallback
+// when the FK graph contains a cycle. targetConn is opened via
+// singleConnBuilder rather than connBuilder's pool, and every table is
+// copied over that same pinned connection, so the deferred constraints set
+// at the start of the transaction actually apply to the connection
+// performing the copy.
+func (sg *SnapshotGenerator) copyTablesWithDeferredConstraints(ctx context.Context, schemaName string, tables []string, snapshotID string) error {
+	targetConn, err := sg.singleConnBuilder(ctx, sg.targetURL)
+	if err != nil {
+		return err
+	}
+	defer targetConn.Close(ctx)
+
+	if _, err := targetConn.Exec(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	if _, err := targetConn.Exec(ctx, "SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		_, _ = targetConn.Exec(ctx, "ROLLBACK")
+		return err
+	}
+
+	var errs []error
+	for _, table := range tables {
+		if err := sg.copyTableWithCheckpoint(ctx, tableToCopy{Schema: schemaName, Table: table, SnapshotID: snapshotID}, targetConn); err != nil {
+			errs = append(errs, fmt.Errorf("copying table %s.%s: %w", schemaName, table, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		_, _ = targetConn.Exec(ctx, "ROLLBACK")
+		return pglib.NewPGRestoreErrors(errs...)
+	}
+
+	_, err = targetConn.Exec(ctx, "COMMIT")
+	return err
+}
+
+// copyTableData is the default dataCopyFn. It opens its own source
+// connection and streams the table's rows into targetConn in Postgres
+// binary COPY format. If targetConn is nil, it dials its own target
+// connection instead and closes it once the copy is done.
+func (sg *SnapshotGenerator) copyTableData(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+	// table.SnapshotID pins srcConn to the exported snapshot across BEGIN,
+	// SET TRANSACTION SNAPSHOT and CopyTo, so it must come from
+	// singleConnBuilder: connBuilder's pool can't guarantee those calls
+	// land on the same physical connection.
+	connBuilder := sg.connBuilder
+	if table.SnapshotID != "" {
+		connBuilder = sg.singleConnBuilder
+	}
+	srcConn, err := connBuilder(ctx, sg.sourceURL)
+	if err != nil {
+		return fmt.Errorf("opening source connection: %w", err)
+	}
+	defer srcConn.Close(ctx)
+
+	if table.SnapshotID != "" {
+		if _, err := srcConn.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			return fmt.Errorf("starting snapshot transaction: %w", err)
+		}
+		defer srcConn.Exec(ctx, "COMMIT")
+		if _, err := srcConn.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", table.SnapshotID)); err != nil {
+			return fmt.Errorf("setting transaction snapshot: %w", err)
+		}
+	}
+
+	tgtConn := targetConn
+	if tgtConn == nil {
+		conn, err := sg.connBuilder(ctx, sg.targetURL)
+		if err != nil {
+			return fmt.Errorf("opening target connection: %w", err)
+		}
+		defer conn.Close(ctx)
+		tgtConn = conn
+	}
+
+	qualifiedTable := pglib.QuoteIdentifier(table.Schema) + "." + pglib.QuoteIdentifier(table.Table)
+	pr, pw := io.Pipe()
+
+	var copyToErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		copyToErr = srcConn.CopyTo(ctx, pw, fmt.Sprintf("COPY (SELECT * FROM only %s) TO STDOUT (FORMAT BINARY)", qualifiedTable))
+		pw.CloseWithError(copyToErr)
+	}()
+
+	_, copyFromErr := tgtConn.CopyFrom(ctx, pr, fmt.Sprintf("COPY %s FROM STDIN (FORMAT BINARY)", qualifiedTable))
+	wg.Wait()
+
+	if copyToErr != nil {
+		return copyToErr
+	}
+	return copyFromErr
+}
+
+// copyTableWithCheckpoint calls sg.dataCopyFn for table, retrying on
+// failure with exponential backoff up to sg.maxAttempts times. When a
+// CheckpointStore is configured, it skips tables already marked done
+// (resuming a previous, partially failed snapshot) and persists the
+// dumping/restoring/done/failed status of every attempt, so a
+// future run can resume from where this one left off. targetConn is
+// forwarded to sg.dataCopyFn as-is (see dataCopyFn).
+func (sg *SnapshotGenerator) copyTableWithCheckpoint(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+	if sg.checkpointStore == nil {
+		return sg.dataCopyFn(ctx, table, targetConn)
+	}
+
+	key := CheckpointKey{SourceURL: sg.sourceURL, Schema: table.Schema, Table: table.Table}
+
+	cp, err := sg.checkpointStore.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("getting checkpoint for %s.%s: %w", table.Schema, table.Table, err)
+	}
+	if cp != nil && cp.Status == CheckpointStatusDone {
+		return nil
+	}
+
+	attempt := uint(0)
+	if cp != nil {
+		attempt = cp.Attempt
+	}
+
+	maxAttempts := sg.maxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 1
+	}
+
+	var lastErr error
+	for attempt < maxAttempts {
+		attempt++
+
+		if attempt > 1 {
+			backoff := sg.retryBackoff * time.Duration(1<<(attempt-2))
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := sg.checkpointStore.Set(ctx, key, Checkpoint{Status: CheckpointStatusDumping, Attempt: attempt}); err != nil {
+			return fmt.Errorf("setting checkpoint for %s.%s: %w", table.Schema, table.Table, err)
+		}
+
+		copyErr := sg.dataCopyFn(ctx, table, targetConn)
+		if isIgnorableCopyErr(copyErr) {
+			copyErr = nil
+		}
+
+		if copyErr == nil {
+			if err := sg.checkpointStore.Set(ctx, key, Checkpoint{Status: CheckpointStatusRestoring, Attempt: attempt}); err != nil {
+				return fmt.Errorf("setting checkpoint for %s.%s: %w", table.Schema, table.Table, err)
+			}
+			return sg.checkpointStore.Set(ctx, key, Checkpoint{Status: CheckpointStatusDone, Attempt: attempt})
+		}
+
+		lastErr = copyErr
+		if err := sg.checkpointStore.Set(ctx, key, Checkpoint{Status: CheckpointStatusFailed, Attempt: attempt, LastErr: copyErr.Error()}); err != nil {
+			return fmt.Errorf("setting checkpoint for %s.%s: %w", table.Schema, table.Table, err)
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", attempt, lastErr)
+}
+
+// isIgnorableCopyErr reports whether err is a data-copy failure that should
+// be treated as a successful checkpoint, e.g. the table was already
+// populated by a previous, partially completed attempt.
+func isIgnorableCopyErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	var restoreErrs *pglib.PGRestoreErrors
+	if errors.As(err, &restoreErrs) {
+		return restoreErrs.Ignorable()
+	}
+	var alreadyExistsErr *pglib.ErrRelationAlreadyExists
+	return errors.As(err, &alreadyExistsErr)
+}
+
+// findFKDependencies is the default fkDependenciesFn. It builds a
+// child->parents map from pg_constraint, restricted to tableNames.
+func findFKDependencies(ctx context.Context, conn pglib.Querier, schemaName string, tableNames []string) (map[string][]string, error) {
+	deps := make(map[string][]string, len(tableNames))
+	for _, table := range tableNames {
+		deps[table] = nil
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT tc.table_name AS child, ccu.table_name AS parent
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.constraint_column_usage ccu
+			ON tc.constraint_name = ccu.constraint_name AND tc.table_schema = ccu.table_schema
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = $1`, schemaName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var child, parent string
+		if err := rows.Scan(&child, &parent); err != nil {
+			return nil, err
+		}
+		if child == parent {
+			continue
+		}
+		if _, ok := deps[child]; !ok {
+			continue
+		}
+		if _, ok := deps[parent]; !ok {
+			continue
+		}
+		deps[child] = append(deps[child], parent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deps, nil
+}
+
+// sortByFKDependency orders tableNames so that a table only ever appears
+// after every table listed as its dependency in deps (Kahn's algorithm). If
+// the graph has a cycle, it returns the original tableNames order and
+// cyclic=true.
+func sortByFKDependency(tableNames []string, deps map[string][]string) (ordered []string, cyclic bool) {
+	inDegree := make(map[string]int, len(tableNames))
+	children := make(map[string][]string, len(tableNames))
+	for _, table := range tableNames {
+		inDegree[table] = 0
+	}
+	for table, parents := range deps {
+		for _, parent := range parents {
+			inDegree[table]++
+			children[parent] = append(children[parent], table)
+		}
+	}
+
+	queue := make([]string, 0, len(tableNames))
+	for _, table := range tableNames {
+		if inDegree[table] == 0 {
+			queue = append(queue, table)
+		}
+	}
+	sort.Strings(queue)
+
+	ordered = make([]string, 0, len(tableNames))
+	for len(queue) > 0 {
+		table := queue[0]
+		queue = queue[1:]
+		ordered = append(ordered, table)
+
+		next := append([]string(nil), children[table]...)
+		sort.Strings(next)
+		for _, child := range next {
+			inDegree[child]--
+			if inDegree[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	if len(ordered) != len(tableNames) {
+		return tableNames, true
+	}
+	return ordered, false
+}
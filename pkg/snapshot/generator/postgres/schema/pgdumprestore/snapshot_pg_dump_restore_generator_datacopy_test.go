@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+	"github.com/xataio/pgstream/internal/postgres/mocks"
+	"github.com/xataio/pgstream/pkg/log"
+)
+
+func TestSortByFKDependency(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		tableNames []string
+		deps       map[string][]string
+
+		wantOrdered []string
+		wantCyclic  bool
+	}{
+		{
+			name:       "no dependencies",
+			tableNames: []string{"b", "a"},
+			deps:       map[string][]string{"a": nil, "b": nil},
+
+			wantOrdered: []string{"a", "b"},
+			wantCyclic:  false,
+		},
+		{
+			name:       "linear chain",
+			tableNames: []string{"orders", "customers", "order_items"},
+			deps: map[string][]string{
+				"customers":   nil,
+				"orders":      {"customers"},
+				"order_items": {"orders"},
+			},
+
+			wantOrdered: []string{"customers", "orders", "order_items"},
+			wantCyclic:  false,
+		},
+		{
+			name:       "cycle",
+			tableNames: []string{"a", "b"},
+			deps: map[string][]string{
+				"a": {"b"},
+				"b": {"a"},
+			},
+
+			wantOrdered: []string{"a", "b"},
+			wantCyclic:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ordered, cyclic := sortByFKDependency(tc.tableNames, tc.deps)
+			require.Equal(t, tc.wantCyclic, cyclic)
+			require.Equal(t, tc.wantOrdered, ordered)
+		})
+	}
+}
+
+func TestSnapshotGenerator_copyData(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("oh noes")
+
+	tests := []struct {
+		name             string
+		fkDependenciesFn fkDependenciesFn
+		dataCopyFn       dataCopyFn
+
+		wantErr error
+	}{
+		{
+			name: "ok - copies parents before children",
+			fkDependenciesFn: func(ctx context.Context, conn pglib.Querier, schemaName string, tableNames []string) (map[string][]string, error) {
+				return map[string][]string{"orders": {"customers"}, "customers": nil}, nil
+			},
+			dataCopyFn: func() dataCopyFn {
+				var mu sync.Mutex
+				copied := map[string]bool{}
+				return func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+					mu.Lock()
+					defer mu.Unlock()
+					if table.Table == "orders" && !copied["customers"] {
+						return errors.New("orders copied before customers")
+					}
+					copied[table.Table] = true
+					return nil
+				}
+			}(),
+
+			wantErr: nil,
+		},
+		{
+			name: "error - fk dependency lookup fails",
+			fkDependenciesFn: func(ctx context.Context, conn pglib.Querier, schemaName string, tableNames []string) (map[string][]string, error) {
+				return nil, errTest
+			},
+			dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+				return errors.New("dataCopyFn: should not be called")
+			},
+
+			wantErr: errTest,
+		},
+		{
+			name: "error - copying a table returns aggregated PGRestoreErrors",
+			fkDependenciesFn: func(ctx context.Context, conn pglib.Querier, schemaName string, tableNames []string) (map[string][]string, error) {
+				return map[string][]string{"orders": nil, "customers": nil}, nil
+			},
+			dataCopyFn: func(ctx context.Context, table tableToCopy, targetConn pglib.Querier) error {
+				return errTest
+			},
+
+			wantErr: pglib.NewPGRestoreErrors(
+				errors.New("copying table test_schema.customers: oh noes"),
+				errors.New("copying table test_schema.orders: oh noes"),
+			),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sg := &SnapshotGenerator{
+				connBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+					return &mocks.Querier{CloseFn: func(ctx context.Context) error { return nil }}, nil
+				},
+				dataCopyConcurrency: 2,
+				fkDependenciesFn:    tc.fkDependenciesFn,
+				logger:              log.NewNoopLogger(),
+				dataCopyFn:          tc.dataCopyFn,
+			}
+
+			err := sg.copyData(context.Background(), "test_schema", []string{"orders", "customers"}, "")
+			if tc.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			var restoreErrs *pglib.PGRestoreErrors
+			require.True(t, errors.As(err, &restoreErrs))
+			var wantErrs *pglib.PGRestoreErrors
+			if errors.As(tc.wantErr, &wantErrs) {
+				gotMsgs := errMsgs(restoreErrs.Errs())
+				wantMsgs := errMsgs(wantErrs.Errs())
+				sort.Strings(gotMsgs)
+				sort.Strings(wantMsgs)
+				require.Equal(t, wantMsgs, gotMsgs)
+				return
+			}
+			require.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestSnapshotGenerator_copyTablesWithDeferredConstraints(t *testing.T) {
+	t.Parallel()
+
+	targetConn := &mocks.Querier{
+		ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+			return pglib.CommandTag{}, nil
+		},
+	}
+
+	var gotConns []pglib.Querier
+	sg := &SnapshotGenerator{
+		singleConnBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+			return targetConn, nil
+		},
+		logger:      log.NewNoopLogger(),
+		maxAttempts: 1,
+		dataCopyFn: func(ctx context.Context, table tableToCopy, conn pglib.Querier) error {
+			gotConns = append(gotConns, conn)
+			return nil
+		},
+	}
+
+	err := sg.copyTablesWithDeferredConstraints(context.Background(), "test_schema", []string{"a", "b"}, "")
+	require.NoError(t, err)
+
+	require.Len(t, gotConns, 2)
+	require.Same(t, targetConn, gotConns[0])
+	require.Same(t, targetConn, gotConns[1])
+}
+
+func TestSnapshotGenerator_copyTableData_snapshotID(t *testing.T) {
+	t.Parallel()
+
+	var gotQueries []string
+	singleConn := &mocks.Querier{
+		ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+			gotQueries = append(gotQueries, query)
+			return pglib.CommandTag{}, nil
+		},
+		CopyToFn: func(ctx context.Context, w io.Writer, query string) error { return nil },
+		CloseFn:  func(ctx context.Context) error { return nil },
+	}
+	targetConn := &mocks.Querier{
+		CopyFromFn: func(ctx context.Context, r io.Reader, query string) (pglib.CommandTag, error) {
+			_, _ = io.Copy(io.Discard, r)
+			return pglib.CommandTag{}, nil
+		},
+	}
+
+	sg := &SnapshotGenerator{
+		sourceURL: "source-url",
+		connBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+			return nil, errors.New("connBuilder: should not be used when a SnapshotID is set")
+		},
+		singleConnBuilder: func(ctx context.Context, s string) (pglib.Querier, error) {
+			return singleConn, nil
+		},
+	}
+
+	table := tableToCopy{Schema: "test_schema", Table: "test_table", SnapshotID: "snapshot-id"}
+	err := sg.copyTableData(context.Background(), table, targetConn)
+	require.NoError(t, err)
+
+	require.Contains(t, gotQueries, "BEGIN ISOLATION LEVEL REPEATABLE READ")
+	require.Contains(t, gotQueries, "SET TRANSACTION SNAPSHOT 'snapshot-id'")
+}
+
+func errMsgs(errs []error) []string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return msgs
+}
@@ -17,6 +17,47 @@ import (
 	"github.com/xataio/pgstream/pkg/snapshot"
 )
 
+type fakeDumpRestorer struct{}
+
+func (f *fakeDumpRestorer) DumpSchema(ctx context.Context, opts pglib.PGDumpOptions) ([]byte, error) {
+	return nil, nil
+}
+func (f *fakeDumpRestorer) DumpData(ctx context.Context, opts pglib.PGDumpOptions) ([]byte, error) {
+	return nil, nil
+}
+func (f *fakeDumpRestorer) RestoreSchema(ctx context.Context, opts pglib.PGRestoreOptions, dump []byte) (string, error) {
+	return "", nil
+}
+func (f *fakeDumpRestorer) RestoreData(ctx context.Context, opts pglib.PGRestoreOptions, dump []byte) (string, error) {
+	return "", nil
+}
+
+func TestNewSnapshotGenerator_backend(t *testing.T) {
+	t.Parallel()
+
+	t.Run("defaults to the shell pg_dump/pg_restore backend", func(t *testing.T) {
+		t.Parallel()
+		sg, err := NewSnapshotGenerator(context.Background(), "source-url", "target-url")
+		require.NoError(t, err)
+		require.IsType(t, &shellDumpRestorer{}, sg.backend)
+	})
+
+	t.Run("WithDumpRestorer overrides the backend", func(t *testing.T) {
+		t.Parallel()
+		backend := &fakeDumpRestorer{}
+		sg, err := NewSnapshotGenerator(context.Background(), "source-url", "target-url", WithDumpRestorer(backend))
+		require.NoError(t, err)
+		require.Same(t, backend, sg.backend)
+	})
+
+	t.Run("WithCatalogBackend switches to the pgx catalog backend", func(t *testing.T) {
+		t.Parallel()
+		sg, err := NewSnapshotGenerator(context.Background(), "source-url", "target-url", WithCatalogBackend())
+		require.NoError(t, err)
+		require.IsType(t, &catalogDumpRestorer{}, sg.backend)
+	})
+}
+
 func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 	t.Parallel()
 
@@ -65,7 +106,7 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				require.Equal(t, pglib.PGDumpOptions{
 					ConnectionString: "source-url",
 					Format:           "p",
@@ -76,7 +117,7 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 				}, po)
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				require.Equal(t, pglib.PGRestoreOptions{
 					ConnectionString: "target-url",
 					SchemaOnly:       true,
@@ -100,7 +141,7 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					return pglib.CommandTag{}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				require.Equal(t, pglib.PGDumpOptions{
 					ConnectionString: "source-url",
 					Format:           "p",
@@ -109,7 +150,7 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 				}, po)
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				require.Equal(t, pglib.PGRestoreOptions{
 					ConnectionString: "target-url",
 					SchemaOnly:       true,
@@ -132,10 +173,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					return pglib.CommandTag{}, errors.New("ExecFn: should not be called")
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return nil, errors.New("pgdumpFn: should not be called")
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errors.New("pgrestoreFn: should not be called")
 			},
 
@@ -152,10 +193,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					return nil, errTest
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return nil, errors.New("pgdumpFn: should not be called")
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errors.New("pgrestoreFn: should not be called")
 			},
 
@@ -180,10 +221,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return nil, errors.New("pgdumpFn: should not be called")
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errors.New("pgrestoreFn: should not be called")
 			},
 
@@ -216,10 +257,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return nil, errors.New("pgdumpFn: should not be called")
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errors.New("pgrestoreFn: should not be called")
 			},
 
@@ -251,10 +292,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return nil, errTest
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errors.New("pgrestoreFn: should not be called")
 			},
 
@@ -286,10 +327,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errTest
 			},
 
@@ -321,10 +362,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", pglib.NewPGRestoreErrors(errTest)
 			},
 
@@ -356,10 +397,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", pglib.NewPGRestoreErrors(&pglib.ErrRelationAlreadyExists{})
 			},
 
@@ -393,10 +434,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					},
 				}, nil
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errors.New("pgrestoreFn: should not be called")
 			},
 
@@ -428,10 +469,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				return "", errors.New("pgrestoreFn: should not be called")
 			},
 
@@ -464,10 +505,10 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 					}, nil
 				},
 			},
-			pgdumpFn: func(po pglib.PGDumpOptions) ([]byte, error) {
+			pgdumpFn: func(ctx context.Context, po pglib.PGDumpOptions) ([]byte, error) {
 				return testDump, nil
 			},
-			pgrestoreFn: func(po pglib.PGRestoreOptions, dump []byte) (string, error) {
+			pgrestoreFn: func(ctx context.Context, po pglib.PGRestoreOptions, dump []byte) (string, error) {
 				require.Equal(t, testDump, dump)
 				return "", nil
 			},
@@ -489,8 +530,7 @@ func TestSnapshotGenerator_CreateSnapshot(t *testing.T) {
 				sourceURL:      "source-url",
 				targetURL:      "target-url",
 				connBuilder:    func(ctx context.Context, s string) (pglib.Querier, error) { return tc.conn, nil },
-				pgDumpFn:       tc.pgdumpFn,
-				pgRestoreFn:    tc.pgrestoreFn,
+				backend:        &shellDumpRestorer{pgDumpFn: tc.pgdumpFn, pgRestoreFn: tc.pgrestoreFn},
 				schemalogStore: tc.schemalogStore,
 				logger:         log.NewNoopLogger(),
 			}
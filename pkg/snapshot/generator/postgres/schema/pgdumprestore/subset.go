@@ -0,0 +1,537 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+)
+
+// defaultMaxKeysInMemory is the default SubsetPolicy.MaxKeysInMemory.
+const defaultMaxKeysInMemory = 100000
+
+// SubsetSelector configures the rows copied for a single seed table of a
+// subset snapshot.
+type SubsetSelector struct {
+	// Table is the seed table the selector applies to.
+	Table string
+	// Where, when set, is appended as-is to the WHERE clause used to
+	// select rows from Table.
+	Where string
+	// Limit, when greater than zero, caps the number of rows selected
+	// from Table.
+	Limit uint
+}
+
+// SubsetPolicy makes CreateSnapshot copy a referentially consistent subset
+// of the source data instead of every row, following the approach used by
+// tools like pg_subsetter: starting from the rows matched by Selectors, it
+// walks the foreign key graph to pull in every parent row required to
+// satisfy an inbound FK, and, if IncludeChildren is set, every child row of
+// a selected parent, before copying the resulting row set into the target.
+type SubsetPolicy struct {
+	Selectors       []SubsetSelector
+	IncludeChildren bool
+	// MaxKeysInMemory bounds how many primary keys are tracked in memory
+	// per table while computing the FK closure, before spilling to a
+	// temp table on the target. Defaults to 100000.
+	MaxKeysInMemory uint
+}
+
+// fkEdge is a single foreign key, child referencing parent via the given
+// column pair.
+type fkEdge struct {
+	ChildTable   string
+	ChildColumn  string
+	ParentTable  string
+	ParentColumn string
+}
+
+// fkGraphFn returns every foreign key defined on a table in schemaName.
+type fkGraphFn func(ctx context.Context, conn pglib.Querier, schemaName string) ([]fkEdge, error)
+
+// primaryKeyColumnFn returns the primary key column of schemaName.table.
+// Composite primary keys aren't supported.
+type primaryKeyColumnFn func(ctx context.Context, conn pglib.Querier, schemaName, table string) (string, error)
+
+// copySubset copies the subset of schemaName's data selected by policy from
+// the source database into the target database, in place of the full
+// per-table data copy.
+func (sg *SnapshotGenerator) copySubset(ctx context.Context, schemaName string, policy SubsetPolicy, snapshotID string) error {
+	// Pinned to a single physical connection whenever snapshotID is set, not
+	// sg.connBuilder's pool: the BEGIN/SET TRANSACTION SNAPSHOT below and
+	// every later query/CopyTo on srcConn must land on the same connection
+	// for the exported snapshot to actually apply to them.
+	srcConnBuilder := sg.connBuilder
+	if snapshotID != "" {
+		srcConnBuilder = sg.singleConnBuilder
+	}
+	srcConn, err := srcConnBuilder(ctx, sg.sourceURL)
+	if err != nil {
+		return fmt.Errorf("opening source connection: %w", err)
+	}
+	defer srcConn.Close(ctx)
+
+	if snapshotID != "" {
+		if _, err := srcConn.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			return fmt.Errorf("starting snapshot transaction: %w", err)
+		}
+		defer srcConn.Exec(ctx, "COMMIT")
+		if _, err := srcConn.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", snapshotID)); err != nil {
+			return fmt.Errorf("setting transaction snapshot: %w", err)
+		}
+	}
+
+	// Pinned to a single physical connection, not sg.connBuilder's pool:
+	// copySubsetTables runs BEGIN/SET CONSTRAINTS DEFERRED and every
+	// table's copy against targetConn, and a pooled Querier wouldn't
+	// guarantee they land on the same connection.
+	targetConn, err := sg.singleConnBuilder(ctx, sg.targetURL)
+	if err != nil {
+		return fmt.Errorf("opening target connection: %w", err)
+	}
+	defer targetConn.Close(ctx)
+
+	edges, err := sg.fkGraphFn(ctx, srcConn, schemaName)
+	if err != nil {
+		return fmt.Errorf("building FK graph: %w", err)
+	}
+
+	closure, err := sg.computeSubsetClosure(ctx, srcConn, targetConn, schemaName, policy, edges)
+	if err != nil {
+		return fmt.Errorf("computing subset closure: %w", err)
+	}
+
+	return sg.copySubsetTables(ctx, srcConn, targetConn, schemaName, closure, edges)
+}
+
+// computeSubsetClosure returns, for every table reachable from policy's
+// seed selectors, the set of primary keys to copy: the rows matched by the
+// selectors, plus every parent row required to satisfy one of their
+// outbound FKs (and, if policy.IncludeChildren is set, every child row of a
+// selected parent). It returns an error if a FK is found that references a
+// parent column other than that parent's primary key, since such a FK can't
+// be resolved to the primary key values this closure tracks.
+func (sg *SnapshotGenerator) computeSubsetClosure(ctx context.Context, srcConn, targetConn pglib.Querier, schemaName string, policy SubsetPolicy, edges []fkEdge) (map[string]*subsetKeySet, error) {
+	outboundFKs := make(map[string][]fkEdge) // child table -> its FKs
+	inboundFKs := make(map[string][]fkEdge)  // parent table -> FKs pointing to it
+	for _, e := range edges {
+		outboundFKs[e.ChildTable] = append(outboundFKs[e.ChildTable], e)
+		inboundFKs[e.ParentTable] = append(inboundFKs[e.ParentTable], e)
+	}
+
+	maxInMemory := policy.MaxKeysInMemory
+	if maxInMemory == 0 {
+		maxInMemory = defaultMaxKeysInMemory
+	}
+
+	closure := map[string]*subsetKeySet{}
+	keySetFor := func(table string) (*subsetKeySet, error) {
+		if ks, ok := closure[table]; ok {
+			return ks, nil
+		}
+		pkColumn, err := sg.primaryKeyColumnFn(ctx, srcConn, schemaName, table)
+		if err != nil {
+			return nil, fmt.Errorf("finding primary key for %s.%s: %w", schemaName, table, err)
+		}
+		ks := newSubsetKeySet(table, pkColumn, maxInMemory)
+		closure[table] = ks
+		return ks, nil
+	}
+
+	type seed struct{ table, key string }
+	var queue []seed
+
+	enqueue := func(table, key string) error {
+		ks, err := keySetFor(table)
+		if err != nil {
+			return err
+		}
+		isNew, err := ks.add(ctx, targetConn, key)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			queue = append(queue, seed{table: table, key: key})
+		}
+		return nil
+	}
+
+	for _, selector := range policy.Selectors {
+		ks, err := keySetFor(selector.Table)
+		if err != nil {
+			return nil, err
+		}
+
+		query := fmt.Sprintf("SELECT %s FROM %s.%s", pglib.QuoteIdentifier(ks.pkColumn), pglib.QuoteIdentifier(schemaName), pglib.QuoteIdentifier(selector.Table))
+		if selector.Where != "" {
+			query += " WHERE " + selector.Where
+		}
+		if selector.Limit > 0 {
+			query += fmt.Sprintf(" LIMIT %d", selector.Limit)
+		}
+
+		rows, err := srcConn.Query(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("selecting seed rows for %s: %w", selector.Table, err)
+		}
+		err = func() error {
+			defer rows.Close()
+			for rows.Next() {
+				var key string
+				if err := rows.Scan(&key); err != nil {
+					return err
+				}
+				if err := enqueue(selector.Table, key); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for _, edge := range outboundFKs[cur.table] {
+			parentKey, err := fetchFKValue(ctx, srcConn, schemaName, cur.table, closure[cur.table].pkColumn, edge.ChildColumn, cur.key)
+			if err != nil {
+				return nil, err
+			}
+			if parentKey == nil {
+				continue
+			}
+
+			// enqueue tracks parentKey under edge.ParentTable's primary key
+			// column, so the FK must reference that column: a FK onto a
+			// non-PK unique column would otherwise silently select the
+			// wrong (or no) rows for the parent table.
+			parentKS, err := keySetFor(edge.ParentTable)
+			if err != nil {
+				return nil, err
+			}
+			if edge.ParentColumn != parentKS.pkColumn {
+				return nil, fmt.Errorf("subset snapshot: foreign key %s.%s(%s) references %s.%s(%s), which is not %s.%s's primary key (%s); foreign keys onto non-primary-key columns aren't supported",
+					schemaName, cur.table, edge.ChildColumn, schemaName, edge.ParentTable, edge.ParentColumn, schemaName, edge.ParentTable, parentKS.pkColumn)
+			}
+
+			if err := enqueue(edge.ParentTable, *parentKey); err != nil {
+				return nil, err
+			}
+		}
+
+		if !policy.IncludeChildren {
+			continue
+		}
+
+		for _, edge := range inboundFKs[cur.table] {
+			childKS, err := keySetFor(edge.ChildTable)
+			if err != nil {
+				return nil, err
+			}
+			childKeys, err := fetchReferencingKeys(ctx, srcConn, schemaName, edge.ChildTable, childKS.pkColumn, edge.ChildColumn, cur.key)
+			if err != nil {
+				return nil, err
+			}
+			for _, childKey := range childKeys {
+				if err := enqueue(edge.ChildTable, childKey); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return closure, nil
+}
+
+// copySubsetTables copies the rows selected by closure into the target
+// database, one table at a time, in FK dependency order, with the target's
+// constraints deferred within a single transaction so that FK cycles don't
+// block the copy.
+func (sg *SnapshotGenerator) copySubsetTables(ctx context.Context, srcConn, targetConn pglib.Querier, schemaName string, closure map[string]*subsetKeySet, edges []fkEdge) error {
+	tableNames := make([]string, 0, len(closure))
+	deps := make(map[string][]string, len(closure))
+	for table, ks := range closure {
+		if ks.empty() {
+			continue
+		}
+		tableNames = append(tableNames, table)
+		deps[table] = nil
+	}
+	for _, e := range edges {
+		if _, ok := deps[e.ChildTable]; !ok {
+			continue
+		}
+		if _, ok := deps[e.ParentTable]; !ok {
+			continue
+		}
+		deps[e.ChildTable] = append(deps[e.ChildTable], e.ParentTable)
+	}
+	ordered, _ := sortByFKDependency(tableNames, deps)
+
+	if _, err := targetConn.Exec(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	if _, err := targetConn.Exec(ctx, "SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		_, _ = targetConn.Exec(ctx, "ROLLBACK")
+		return err
+	}
+
+	var errs []error
+	for _, table := range ordered {
+		if err := sg.copySubsetTable(ctx, srcConn, targetConn, schemaName, table, closure[table]); err != nil {
+			errs = append(errs, fmt.Errorf("copying subset of table %s.%s: %w", schemaName, table, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		_, _ = targetConn.Exec(ctx, "ROLLBACK")
+		return pglib.NewPGRestoreErrors(errs...)
+	}
+
+	_, err := targetConn.Exec(ctx, "COMMIT")
+	return err
+}
+
+// copySubsetTable streams the rows selected by ks for schemaName.table from
+// the source database to the target database, in Postgres binary COPY
+// format.
+func (sg *SnapshotGenerator) copySubsetTable(ctx context.Context, srcConn, targetConn pglib.Querier, schemaName, table string, ks *subsetKeySet) error {
+	qualifiedTable := pglib.QuoteIdentifier(schemaName) + "." + pglib.QuoteIdentifier(table)
+
+	selectQuery, err := ks.selectQuery(ctx, targetConn, qualifiedTable)
+	if err != nil {
+		return fmt.Errorf("building subset query: %w", err)
+	}
+	if selectQuery == "" {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+
+	var copyToErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		copyToErr = srcConn.CopyTo(ctx, pw, fmt.Sprintf("COPY (%s) TO STDOUT (FORMAT BINARY)", selectQuery))
+		pw.CloseWithError(copyToErr)
+	}()
+
+	_, copyFromErr := targetConn.CopyFrom(ctx, pr, fmt.Sprintf("COPY %s FROM STDIN (FORMAT BINARY)", qualifiedTable))
+	wg.Wait()
+
+	if copyToErr != nil {
+		return copyToErr
+	}
+	return copyFromErr
+}
+
+// subsetKeySet tracks the set of primary keys selected for a single table
+// while computing a subset closure, spilling to a temp table on the target
+// once more than maxInMemory keys have been seen.
+type subsetKeySet struct {
+	table       string
+	pkColumn    string
+	maxInMemory uint
+	keys        map[string]struct{}
+	spillTable  string
+}
+
+func newSubsetKeySet(table, pkColumn string, maxInMemory uint) *subsetKeySet {
+	return &subsetKeySet{table: table, pkColumn: pkColumn, maxInMemory: maxInMemory, keys: make(map[string]struct{})}
+}
+
+// add records key as selected for this table, spilling the in-memory set
+// to a temp table on targetConn if it would otherwise grow past
+// maxInMemory. It returns whether key hadn't already been recorded.
+func (ks *subsetKeySet) add(ctx context.Context, targetConn pglib.Querier, key string) (bool, error) {
+	if ks.spillTable != "" {
+		return ks.addSpilled(ctx, targetConn, key)
+	}
+
+	if _, ok := ks.keys[key]; ok {
+		return false, nil
+	}
+
+	if uint(len(ks.keys)) < ks.maxInMemory {
+		ks.keys[key] = struct{}{}
+		return true, nil
+	}
+
+	if err := ks.spill(ctx, targetConn); err != nil {
+		return false, err
+	}
+	return ks.addSpilled(ctx, targetConn, key)
+}
+
+func (ks *subsetKeySet) spill(ctx context.Context, targetConn pglib.Querier) error {
+	ks.spillTable = fmt.Sprintf("subset_keys_%s", ks.table)
+	if _, err := targetConn.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE IF NOT EXISTS %s (key text PRIMARY KEY)", pglib.QuoteIdentifier(ks.spillTable))); err != nil {
+		return err
+	}
+	for key := range ks.keys {
+		if _, err := targetConn.Exec(ctx, fmt.Sprintf("INSERT INTO %s (key) VALUES ($1) ON CONFLICT DO NOTHING", pglib.QuoteIdentifier(ks.spillTable)), key); err != nil {
+			return err
+		}
+	}
+	ks.keys = nil
+	return nil
+}
+
+func (ks *subsetKeySet) addSpilled(ctx context.Context, targetConn pglib.Querier, key string) (bool, error) {
+	rows, err := targetConn.Query(ctx, fmt.Sprintf("INSERT INTO %s (key) VALUES ($1) ON CONFLICT DO NOTHING RETURNING key", pglib.QuoteIdentifier(ks.spillTable)), key)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	isNew := rows.Next()
+	return isNew, rows.Err()
+}
+
+func (ks *subsetKeySet) empty() bool {
+	return ks.spillTable == "" && len(ks.keys) == 0
+}
+
+// selectQuery returns the `SELECT * FROM qualifiedTable WHERE ...` query
+// matching every key selected for this table, or "" if none were selected.
+func (ks *subsetKeySet) selectQuery(ctx context.Context, targetConn pglib.Querier, qualifiedTable string) (string, error) {
+	keys, err := ks.allKeys(ctx, targetConn)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+
+	literals := make([]string, len(keys))
+	for i, key := range keys {
+		literals[i] = quoteLiteral(key)
+	}
+	sort.Strings(literals)
+
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s)", qualifiedTable, pglib.QuoteIdentifier(ks.pkColumn), strings.Join(literals, ", ")), nil
+}
+
+func (ks *subsetKeySet) allKeys(ctx context.Context, targetConn pglib.Querier) ([]string, error) {
+	if ks.spillTable == "" {
+		keys := make([]string, 0, len(ks.keys))
+		for key := range ks.keys {
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	rows, err := targetConn.Query(ctx, fmt.Sprintf("SELECT key FROM %s", pglib.QuoteIdentifier(ks.spillTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// fetchFKValue returns the value of fkColumn on the row of schemaName.table
+// identified by pkColumn = pkValue, or nil if it's NULL.
+func fetchFKValue(ctx context.Context, conn pglib.Querier, schemaName, table, pkColumn, fkColumn, pkValue string) (*string, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s = $1", pglib.QuoteIdentifier(fkColumn), pglib.QuoteIdentifier(schemaName), pglib.QuoteIdentifier(table), pglib.QuoteIdentifier(pkColumn))
+	var value *string
+	if err := conn.QueryRow(ctx, query, pkValue).Scan(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// fetchReferencingKeys returns the primary keys of every row in
+// schemaName.childTable whose fkColumn references parentKeyValue.
+func fetchReferencingKeys(ctx context.Context, conn pglib.Querier, schemaName, childTable, childPKColumn, fkColumn, parentKeyValue string) ([]string, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s = $1", pglib.QuoteIdentifier(childPKColumn), pglib.QuoteIdentifier(schemaName), pglib.QuoteIdentifier(childTable), pglib.QuoteIdentifier(fkColumn))
+	rows, err := conn.Query(ctx, query, parentKeyValue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// findFKGraph is the default fkGraphFn. It queries pg_catalog.pg_constraint
+// directly for every single-column foreign key defined on a table in
+// schemaName.
+func findFKGraph(ctx context.Context, conn pglib.Querier, schemaName string) ([]fkEdge, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT
+			child.relname AS child_table,
+			childcol.attname AS child_column,
+			parent.relname AS parent_table,
+			parentcol.attname AS parent_column
+		FROM pg_catalog.pg_constraint con
+		JOIN pg_catalog.pg_class child ON child.oid = con.conrelid
+		JOIN pg_catalog.pg_class parent ON parent.oid = con.confrelid
+		JOIN pg_catalog.pg_namespace ns ON ns.oid = child.relnamespace
+		JOIN pg_catalog.pg_attribute childcol ON childcol.attrelid = con.conrelid AND childcol.attnum = con.conkey[1]
+		JOIN pg_catalog.pg_attribute parentcol ON parentcol.attrelid = con.confrelid AND parentcol.attnum = con.confkey[1]
+		WHERE con.contype = 'f' AND ns.nspname = $1`, schemaName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edges []fkEdge
+	for rows.Next() {
+		var e fkEdge
+		if err := rows.Scan(&e.ChildTable, &e.ChildColumn, &e.ParentTable, &e.ParentColumn); err != nil {
+			return nil, err
+		}
+		edges = append(edges, e)
+	}
+	return edges, rows.Err()
+}
+
+// findPrimaryKeyColumn is the default primaryKeyColumnFn. Composite primary
+// keys aren't supported.
+func findPrimaryKeyColumn(ctx context.Context, conn pglib.Querier, schemaName, table string) (string, error) {
+	var column string
+	err := conn.QueryRow(ctx, `
+		SELECT a.attname
+		FROM pg_catalog.pg_index i
+		JOIN pg_catalog.pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		JOIN pg_catalog.pg_class c ON c.oid = i.indrelid
+		JOIN pg_catalog.pg_namespace ns ON ns.oid = c.relnamespace
+		WHERE i.indisprimary AND c.relname = $1 AND ns.nspname = $2
+		LIMIT 1`, table, schemaName).Scan(&column)
+	if err != nil {
+		return "", err
+	}
+	return column, nil
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pgdumprestore
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pglib "github.com/xataio/pgstream/internal/postgres"
+	"github.com/xataio/pgstream/internal/postgres/mocks"
+)
+
+func TestSubsetKeySet_add(t *testing.T) {
+	t.Parallel()
+
+	t.Run("stays in memory under the limit", func(t *testing.T) {
+		t.Parallel()
+		ks := newSubsetKeySet("orders", "id", 2)
+
+		isNew, err := ks.add(context.Background(), nil, "1")
+		require.NoError(t, err)
+		require.True(t, isNew)
+
+		isNew, err = ks.add(context.Background(), nil, "1")
+		require.NoError(t, err)
+		require.False(t, isNew)
+		require.Empty(t, ks.spillTable)
+	})
+
+	t.Run("spills to a temp table once over the limit", func(t *testing.T) {
+		t.Parallel()
+		ks := newSubsetKeySet("orders", "id", 1)
+
+		_, err := ks.add(context.Background(), nil, "1")
+		require.NoError(t, err)
+
+		var gotCreate, gotInsert, gotReturningInsert string
+		targetConn := &mocks.Querier{
+			ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+				switch {
+				case strings.HasPrefix(query, "CREATE TEMP TABLE"):
+					gotCreate = query
+				case strings.Contains(query, "ON CONFLICT DO NOTHING") && !strings.Contains(query, "RETURNING"):
+					gotInsert = query
+				}
+				return pglib.CommandTag{}, nil
+			},
+			QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+				gotReturningInsert = query
+				return rowsOf([]string{"2"}), nil
+			},
+		}
+
+		isNew, err := ks.add(context.Background(), targetConn, "2")
+		require.NoError(t, err)
+		require.True(t, isNew)
+		require.Equal(t, `CREATE TEMP TABLE IF NOT EXISTS "subset_keys_orders" (key text PRIMARY KEY)`, gotCreate)
+		require.Contains(t, gotInsert, `"subset_keys_orders"`)
+		require.Contains(t, gotReturningInsert, "RETURNING key")
+		require.Equal(t, "subset_keys_orders", ks.spillTable)
+	})
+}
+
+func TestSubsetKeySet_selectQuery(t *testing.T) {
+	t.Parallel()
+
+	t.Run("in-memory", func(t *testing.T) {
+		t.Parallel()
+		ks := newSubsetKeySet("orders", "id", 10)
+		_, err := ks.add(context.Background(), nil, "2")
+		require.NoError(t, err)
+		_, err = ks.add(context.Background(), nil, "1")
+		require.NoError(t, err)
+
+		query, err := ks.selectQuery(context.Background(), nil, `"test_schema"."orders"`)
+		require.NoError(t, err)
+		require.Equal(t, `SELECT * FROM "test_schema"."orders" WHERE "id" IN ('1', '2')`, query)
+	})
+
+	t.Run("no keys selected", func(t *testing.T) {
+		t.Parallel()
+		ks := newSubsetKeySet("orders", "id", 10)
+
+		query, err := ks.selectQuery(context.Background(), nil, `"test_schema"."orders"`)
+		require.NoError(t, err)
+		require.Empty(t, query)
+	})
+
+	t.Run("spilled", func(t *testing.T) {
+		t.Parallel()
+		ks := newSubsetKeySet("orders", "id", 10)
+		ks.spillTable = "subset_keys_orders"
+
+		targetConn := &mocks.Querier{
+			QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+				require.Equal(t, `SELECT key FROM "subset_keys_orders"`, query)
+				return rowsOf([]string{"1", "2"}), nil
+			},
+		}
+
+		query, err := ks.selectQuery(context.Background(), targetConn, `"test_schema"."orders"`)
+		require.NoError(t, err)
+		require.Equal(t, `SELECT * FROM "test_schema"."orders" WHERE "id" IN ('1', '2')`, query)
+	})
+}
+
+func TestSnapshotGenerator_computeSubsetClosure(t *testing.T) {
+	t.Parallel()
+
+	srcConn := &mocks.Querier{
+		QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+			switch {
+			case strings.Contains(query, `FROM "test_schema"."orders"`):
+				return rowsOf([]string{"1"}), nil
+			default:
+				return rowsOf(nil), nil
+			}
+		},
+		QueryRowFn: func(ctx context.Context, query string, args ...any) pglib.Row {
+			require.Equal(t, `SELECT "customer_id" FROM "test_schema"."orders" WHERE "id" = $1`, query)
+			require.Equal(t, "1", args[0])
+			return &mocks.Row{
+				ScanFn: func(dest ...any) error {
+					val, ok := dest[0].(**string)
+					require.True(t, ok)
+					customerID := "10"
+					*val = &customerID
+					return nil
+				},
+			}
+		},
+	}
+
+	sg := &SnapshotGenerator{
+		fkGraphFn: func(ctx context.Context, conn pglib.Querier, schemaName string) ([]fkEdge, error) {
+			return []fkEdge{{ChildTable: "orders", ChildColumn: "customer_id", ParentTable: "customers", ParentColumn: "id"}}, nil
+		},
+		primaryKeyColumnFn: func(ctx context.Context, conn pglib.Querier, schemaName, table string) (string, error) {
+			return "id", nil
+		},
+	}
+
+	policy := SubsetPolicy{Selectors: []SubsetSelector{{Table: "orders"}}}
+	edges, err := sg.fkGraphFn(context.Background(), srcConn, "test_schema")
+	require.NoError(t, err)
+
+	closure, err := sg.computeSubsetClosure(context.Background(), srcConn, nil, "test_schema", policy, edges)
+	require.NoError(t, err)
+
+	require.ElementsMatch(t, []string{"1"}, keysOf(t, closure["orders"]))
+	require.ElementsMatch(t, []string{"10"}, keysOf(t, closure["customers"]))
+}
+
+func TestSnapshotGenerator_computeSubsetClosure_fkNotOnPrimaryKey(t *testing.T) {
+	t.Parallel()
+
+	srcConn := &mocks.Querier{
+		QueryFn: func(ctx context.Context, query string, args ...any) (pglib.Rows, error) {
+			switch {
+			case strings.Contains(query, `FROM "test_schema"."orders"`):
+				return rowsOf([]string{"1"}), nil
+			default:
+				return rowsOf(nil), nil
+			}
+		},
+		QueryRowFn: func(ctx context.Context, query string, args ...any) pglib.Row {
+			return &mocks.Row{
+				ScanFn: func(dest ...any) error {
+					val, ok := dest[0].(**string)
+					require.True(t, ok)
+					code := "abc"
+					*val = &code
+					return nil
+				},
+			}
+		},
+	}
+
+	sg := &SnapshotGenerator{
+		fkGraphFn: func(ctx context.Context, conn pglib.Querier, schemaName string) ([]fkEdge, error) {
+			// customers.code is a unique column, not customers' primary key (id).
+			return []fkEdge{{ChildTable: "orders", ChildColumn: "customer_code", ParentTable: "customers", ParentColumn: "code"}}, nil
+		},
+		primaryKeyColumnFn: func(ctx context.Context, conn pglib.Querier, schemaName, table string) (string, error) {
+			return "id", nil
+		},
+	}
+
+	policy := SubsetPolicy{Selectors: []SubsetSelector{{Table: "orders"}}}
+	edges, err := sg.fkGraphFn(context.Background(), srcConn, "test_schema")
+	require.NoError(t, err)
+
+	_, err = sg.computeSubsetClosure(context.Background(), srcConn, nil, "test_schema", policy, edges)
+	require.ErrorContains(t, err, "customers")
+	require.ErrorContains(t, err, "code")
+}
+
+func TestSnapshotGenerator_copySubsetTables(t *testing.T) {
+	t.Parallel()
+
+	customers := newSubsetKeySet("customers", "id", 10)
+	_, _ = customers.add(context.Background(), nil, "10")
+	orders := newSubsetKeySet("orders", "id", 10)
+	_, _ = orders.add(context.Background(), nil, "1")
+
+	closure := map[string]*subsetKeySet{"customers": customers, "orders": orders}
+	edges := []fkEdge{{ChildTable: "orders", ChildColumn: "customer_id", ParentTable: "customers", ParentColumn: "id"}}
+
+	var copyOrder []string
+	srcConn := &mocks.Querier{
+		CopyToFn: func(ctx context.Context, w io.Writer, query string) error {
+			switch {
+			case strings.Contains(query, `"test_schema"."customers"`):
+				copyOrder = append(copyOrder, "customers")
+				require.Contains(t, query, `WHERE "id" IN ('10')`)
+			case strings.Contains(query, `"test_schema"."orders"`):
+				copyOrder = append(copyOrder, "orders")
+				require.Contains(t, query, `WHERE "id" IN ('1')`)
+			}
+			return nil
+		},
+	}
+	targetConn := &mocks.Querier{
+		ExecFn: func(ctx context.Context, i uint, query string, args ...any) (pglib.CommandTag, error) {
+			return pglib.CommandTag{}, nil
+		},
+		CopyFromFn: func(ctx context.Context, r io.Reader, query string) (pglib.CommandTag, error) {
+			_, _ = io.Copy(io.Discard, r)
+			return pglib.CommandTag{}, nil
+		},
+	}
+
+	sg := &SnapshotGenerator{}
+	err := sg.copySubsetTables(context.Background(), srcConn, targetConn, "test_schema", closure, edges)
+	require.NoError(t, err)
+	require.Equal(t, []string{"customers", "orders"}, copyOrder)
+}
+
+func keysOf(t *testing.T, ks *subsetKeySet) []string {
+	t.Helper()
+	keys, err := ks.allKeys(context.Background(), nil)
+	require.NoError(t, err)
+	return keys
+}
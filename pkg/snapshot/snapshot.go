@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package snapshot
+
+// Snapshot represents a request to snapshot a given schema, optionally
+// restricted to a subset of its tables.
+type Snapshot struct {
+	SchemaName string
+	// TableNames is the list of tables to snapshot. A single entry of "*"
+	// means all tables in the schema.
+	TableNames []string
+}